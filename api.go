@@ -0,0 +1,239 @@
+package main
+
+import (
+	"time"
+)
+
+// Request and response bodies of the api, also used to generate the openapi schemas
+// Requests are read from json or form data by bindRequest, their binding tags are the validation rules
+
+type errorBody struct {
+	Name      string       `json:"name"`
+	Message   string       `json:"message"`
+	RequestId string       `json:"requestId"`
+	Fields    []fieldError `json:"fields,omitempty"` // only set for invalid fields
+}
+
+type createArticleRequest struct {
+	ImageUrl  string      `json:"imageUrl" binding:"required"`
+	Title     string      `json:"title" binding:"required,title"`
+	Body      string      `json:"body" binding:"required,min=300,max=10000"`
+	Tags      string      `json:"tags" binding:"required,tags"`
+	Captcha   string      `json:"captcha,omitempty"`
+	Media     []mediaItem `json:"media,omitempty" binding:"max=12,dive"`
+	Latitude  *float64    `json:"latitude,omitempty" binding:"required_with=Longitude,omitempty,min=-90,max=90"`
+	Longitude *float64    `json:"longitude,omitempty" binding:"required_with=Latitude,omitempty,min=-180,max=180"`
+	PlaceName string      `json:"placeName,omitempty" binding:"max=75"`
+	ReplaceId int         `json:"replaceId,omitempty" binding:"omitempty,min=1"` // id of an own article to replace
+}
+
+type heartRequest struct {
+	ArticleId int `json:"articleId" binding:"required,min=1"`
+}
+
+type bookmarkRequest struct {
+	Folder string `json:"folder,omitempty" binding:"max=50"` // empty for no folder
+}
+
+type loginUrlResponse struct {
+	LoginUrl string `json:"loginUrl"`
+}
+
+type accessTokenResponse struct {
+	AccessToken string `json:"accessToken"`
+}
+
+type userDataResponse struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Picture string `json:"picture"`
+}
+
+// An article as listed by search and user articles
+type articleSummary struct {
+	Id       int       `json:"id"`
+	Author   string    `json:"author"`
+	ImageUrl string    `json:"imageUrl"`
+	Title    string    `json:"title"`
+	Tags     []string  `json:"tags"`
+	Views    int       `json:"views"`
+	Hearts   int       `json:"hearts"`
+	Created  time.Time `json:"created"`
+}
+
+type articleListResponse struct {
+	Count    int              `json:"count"`
+	Articles []articleSummary `json:"articles"`
+}
+
+type articleResponse struct {
+	Id             int               `json:"id"`
+	Author         string            `json:"author"`
+	AuthorGoogleId string            `json:"authorGoogleId"` // salted
+	ImageUrl       string            `json:"imageUrl"`
+	Title          string            `json:"title"`
+	Body           string            `json:"body"`
+	Tags           []string          `json:"tags"`
+	Media          []mediaItem       `json:"media"`
+	Location       *location         `json:"location"`
+	Views          int               `json:"views"`
+	Hearts         int               `json:"hearts"`
+	Created        time.Time         `json:"created"`
+	Updated        time.Time         `json:"updated"`            // last change to the content
+	Bookmark       *bookmarkResponse `json:"bookmark,omitempty"` // only for signed in users
+}
+
+type createArticleResponse struct {
+	Id   int  `json:"id"`
+	Held bool `json:"held"` // held for moderation by the spam filter
+}
+
+type articleIdResponse struct {
+	Id int `json:"id"`
+}
+
+// Response of the deprecated unversioned delete route, which always sent the id as given in the path
+type legacyArticleIdResponse struct {
+	Id string `json:"id"`
+}
+
+type tagsResponse struct {
+	Tags []string `json:"tags"`
+}
+
+type imageUrlResponse struct {
+	Url string `json:"url"`
+}
+
+type heartedResponse struct {
+	Hearted bool `json:"hearted"`
+}
+
+type bookmarkResponse struct {
+	Bookmarked bool   `json:"bookmarked"`
+	Folder     string `json:"folder"`
+}
+
+type bookmarkFoldersResponse struct {
+	Folders []bookmarkFolder `json:"folders"`
+}
+
+type bookmarkFolder struct {
+	Folder string `json:"folder"` // empty for bookmarks without a folder
+	Count  int    `json:"count"`
+}
+
+type reportResponse struct {
+	ArticleId int    `json:"articleId"`
+	Reason    string `json:"reason"`
+}
+
+type report struct {
+	Id        int       `json:"id"`
+	ArticleId int       `json:"articleId"`
+	Title     string    `json:"title"`
+	Hidden    bool      `json:"hidden"`
+	Reason    string    `json:"reason"`
+	Details   string    `json:"details"`
+	Status    string    `json:"status"`
+	Created   time.Time `json:"created"`
+}
+
+type reportsResponse struct {
+	Count   int      `json:"count"`
+	Reports []report `json:"reports"`
+}
+
+type resolveReportResponse struct {
+	ArticleId int    `json:"articleId"`
+	Action    string `json:"action"`
+}
+
+type moderationLogEntry struct {
+	Id        int       `json:"id"`
+	Moderator string    `json:"moderator"`
+	ArticleId int       `json:"articleId"`
+	ReportId  *int64    `json:"reportId,omitempty"`
+	Action    string    `json:"action"`
+	Note      string    `json:"note"`
+	Created   time.Time `json:"created"`
+}
+
+type moderationLogResponse struct {
+	Count   int                  `json:"count"`
+	Entries []moderationLogEntry `json:"entries"`
+}
+
+type warning struct {
+	ArticleId int       `json:"articleId"`
+	Note      string    `json:"note"`
+	Created   time.Time `json:"created"`
+}
+
+type warningsResponse struct {
+	Count    int       `json:"count"`
+	Warnings []warning `json:"warnings"`
+}
+
+type bannedWord struct {
+	Word string  `json:"word"`
+	Risk float64 `json:"risk"`
+}
+
+type bannedWordsResponse struct {
+	Count       int          `json:"count"`
+	BannedWords []bannedWord `json:"bannedWords"`
+}
+
+type removedWordResponse struct {
+	Word string `json:"word"`
+}
+
+type banResponse struct {
+	UserId       string     `json:"userId"`
+	Expires      *time.Time `json:"expires"` // null for permanent bans
+	HideArticles bool       `json:"hideArticles"`
+}
+
+type ban struct {
+	UserId       string     `json:"userId"`
+	Reason       string     `json:"reason"`
+	Expires      *time.Time `json:"expires"`
+	HideArticles bool       `json:"hideArticles"`
+	AppealNote   string     `json:"appealNote"`
+	BannedBy     string     `json:"bannedBy"`
+	Created      time.Time  `json:"created"`
+}
+
+type bansResponse struct {
+	Count int   `json:"count"`
+	Bans  []ban `json:"bans"`
+}
+
+type userIdResponse struct {
+	UserId string `json:"userId"`
+}
+
+type userBanResponse struct {
+	Reason     string     `json:"reason"`
+	Expires    *time.Time `json:"expires"`
+	AppealNote string     `json:"appealNote"`
+}
+
+type appealResponse struct {
+	AppealNote string `json:"appealNote"`
+}
+
+// A cluster of articles on the map, articleId is only set for single articles
+type mapPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Count     int     `json:"count"`
+	ArticleId *int    `json:"articleId,omitempty"`
+}
+
+type mapResponse struct {
+	Count  int        `json:"count"`
+	Points []mapPoint `json:"points"`
+}
@@ -1,84 +1,145 @@
 package main
 
 import (
-	"database/sql"
-	"fmt"
-	"log"
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/joho/godotenv"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
-var (
-	port              string
-	signInUrl         string
-	imagePath         string
-	stateSalt         string
-	googleIdSalt      string
-	googleOauthConfig *oauth2.Config
-	psqlInfo          string
-	reCaptchaSecret   string
-	db                *sql.DB
-	awsBucket         string
-	awsSession        *session.Session
-	adminEmail        string
-)
+func main() {
+	// Manage the database schema instead of serving (ex. "application migrate up")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := migrateCommand(os.Args[2:])
+		if err != nil {
+			fatal(slog.Default(), "failed to migrate database", err)
+		}
+		return
+	}
+	// Recompute the recommendations once instead of serving (ex. "application recommend" from cron)
+	if len(os.Args) > 1 && os.Args[1] == "recommend" {
+		err := recommendCommand(os.Args[2:])
+		if err != nil {
+			fatal(slog.Default(), "failed to recommend articles", err)
+		}
+		return
+	}
 
-func connectToDB() {
-	var err error
-	db, err = sql.Open("postgres", psqlInfo)
+	// Load and validate configuration
+	config, err := loadConfig(os.Args[1:])
 	if err != nil {
-		panic(err)
+		fatal(slog.Default(), "failed to load configuration", err)
 	}
-	//defer db.Close()
-	err = db.Ping()
+	logger := newLogger(os.Stdout, config.LogLevel, config.LogFormat)
+	slog.SetDefault(logger)
+	logger.Info("loaded configuration")
+
+	shutdownTracing, err := setupTracing(config)
 	if err != nil {
-		panic(err)
+		fatal(logger, "failed to set up tracing", err)
 	}
-}
 
-func main() {
-	// Load env vars
-	err := godotenv.Load("./secrets.env")
+	identity := metricsIdentityProvider{newGoogleIdentityProvider(config.GoogleClientId, config.GoogleClientSecret, config.SignInUrl)}
+	logger.Info("loaded google oauth")
+
+	captcha, err := newCaptchaVerifier(config.CaptchaProvider, config.CaptchaSecret, config.CaptchaThresholds, config.CaptchaHostnames)
 	if err != nil {
-		fmt.Println("failed to load ./secrets.env file (fatal)")
-		log.Fatal("failed to load ./secrets.env file (fatal)")
+		fatal(logger, "failed to load captcha verifier", err)
 	}
+	logger.Info("loaded captcha verifier", "provider", config.CaptchaProvider)
+
+	awsSession := session.Must(session.NewSession(&aws.Config{Region: aws.String(config.AwsRegion)}))
+	blobs := metricsBlobStore{tracingBlobStore{newS3BlobStore(awsSession, config.AwsBucket)}}
+	logger.Info("loaded aws session")
 
-	port = os.Getenv("PORT") // port env var is passed by aws
-	if port == "" {
-		port = "5000"
-		fmt.Println("no port env found setting default port (warning)")
+	db, err := connectToDB(config.PsqlInfo, config.DBConnectRetry, logger)
+	if err != nil {
+		fatal(logger, "failed to connect to database", err)
 	}
-	signInUrl = os.Getenv("SIGN_IN_URL")
-	imagePath = os.Getenv("IMAGE_PATH")
-	stateSalt = os.Getenv("STATE_SALT")
-	googleIdSalt = os.Getenv("GOOGLE_ID_SALT")
-	psqlInfo = os.Getenv("PSQL_INFO")
-	reCaptchaSecret = os.Getenv("RECAPTCHA_SECRET")
-	awsBucket = os.Getenv("AWS_S3_BUCKET")
-	adminEmail = os.Getenv("ADMIN_EMAIL")
-	fmt.Println("[SUCCESS] loaded env vars")
-
-	// Configure google oauth
-	googleOauthConfig = &oauth2.Config{
-		RedirectURL:  signInUrl,
-		ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-		ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
-		Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"},
-		Endpoint:     google.Endpoint,
+	configurePool(db, config)
+	logger.Info("connected to database")
+
+	if config.AutoMigrate {
+		m, err := newMigrator(db, logger)
+		if err != nil {
+			fatal(logger, "failed to load migrations", err)
+		}
+		count, err := m.Up(context.Background())
+		if err != nil {
+			fatal(logger, "failed to migrate database", err)
+		}
+		logger.Info("migrated database", "applied", count)
 	}
-	fmt.Println("loaded google oauth")
 
-	awsSession = session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-1")}))
-	fmt.Println("loaded aws session")
+	server := NewServer(config, newSQLStore(db, config.DBQueryTimeout), blobs, identity, captcha, systemClock{}, logger)
+	server.Start()
 
-	connectToDB()
-	fmt.Println("connected to database")
+	httpServer := &http.Server{
+		Addr:              ":" + config.Port,
+		Handler:           server.Handler(),
+		ReadTimeout:       config.ReadTimeout,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+	if !config.HTTP2 {
+		// A non nil empty map disables the automatic http2 upgrade
+		httpServer.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+
+	// Serve until the server fails or we are asked to stop
+	serveErr := make(chan error, 1)
+	go func() {
+		if config.TLSCertFile != "" {
+			serveErr <- httpServer.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile)
+		} else {
+			serveErr <- httpServer.ListenAndServe()
+		}
+	}()
+	logger.Info("listening", "port", config.Port)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	failed := false
+	select {
+	case err = <-serveErr:
+		// Still shut down cleanly, then exit non zero so that supervisors see the failure
+		logger.Error("server failed", "error", err)
+		failed = true
+	case sig := <-signals:
+		logger.Info("shutting down", "signal", sig.String())
+	}
+
+	// Let in flight requests (and their s3 uploads) finish before closing the database
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	err = httpServer.Shutdown(ctx)
+	if err != nil {
+		logger.Warn("failed to drain connections", "error", err)
+	}
+	err = server.Close()
+	if err != nil {
+		logger.Warn("failed to close server", "error", err)
+	}
+	err = shutdownTracing(ctx)
+	if err != nil {
+		logger.Warn("failed to flush traces", "error", err)
+	}
+	cancel()
+	logger.Info("shut down")
+	if failed {
+		os.Exit(1)
+	}
+}
 
-	handleRouting()
+// Logs a startup error and exits
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxBanReasonLength  = 500
+	maxAppealNoteLength = 1000
+)
+
+// Salted id of a user as exposed by s.userDataHandler
+func (s *Server) saltedUserId(googleId interface{}) string {
+	id, _ := googleId.(string)
+	return toSHA1(id + s.config.GoogleIdSalt)
+}
+
+// Finds the google id behind a salted id among authors, null if the user never wrote an article
+func (s *Server) resolveGoogleId(ctx context.Context, userId string) (sql.NullString, error) {
+	var googleId sql.NullString
+	err := s.store.QueryRowContext(ctx, `SELECT google_id FROM user_ids WHERE user_id=$1`, userId).Scan(&googleId)
+	if err == sql.ErrNoRows {
+		return googleId, nil
+	}
+	return googleId, err
+}
+
+// Remembers the google id behind the salted id of an author, in the transaction writing their article
+func (s *Server) rememberUserId(ctx context.Context, ex execer, googleId string) error {
+	q := `INSERT INTO user_ids (user_id, google_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	_, err := ex.ExecContext(ctx, q, s.saltedUserId(googleId), googleId)
+	return err
+}
+
+// Remembers authors of articles written before user_ids existed and resolves bans made before their author was known
+// (run periodically, cheap once every author is known)
+func (s *Server) rememberUserIdsJob(ctx context.Context) error {
+	q := `SELECT DISTINCT author_google_id FROM articles
+	WHERE NOT EXISTS (SELECT 1 FROM user_ids WHERE user_ids.google_id = articles.author_google_id)`
+	rows, err := s.store.QueryContext(ctx, q)
+	if err != nil {
+		return err
+	}
+	var googleIds []string
+	for rows.Next() {
+		var googleId string
+		err = rows.Scan(&googleId)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		googleIds = append(googleIds, googleId)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, googleId := range googleIds {
+		err = s.rememberUserId(ctx, s.store, googleId)
+		if err != nil {
+			return err
+		}
+	}
+
+	q = `UPDATE bans SET google_id = user_ids.google_id FROM user_ids WHERE bans.user_id = user_ids.user_id AND bans.google_id IS NULL`
+	result, err := s.store.ExecContext(ctx, q)
+	if err != nil {
+		return err
+	}
+	if resolved, _ := result.RowsAffected(); resolved > 0 {
+		// Articles of the resolved users may disappear
+		s.invalidateArticles(ctx)
+		s.logger.Info("resolved bans", "count", resolved)
+	}
+	return nil
+}
+
+// Middleware to reject banned or suspended users (must come after s.accessTokenMiddleware)
+func (s *Server) banMiddleware(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	googleId, _ := c.Get("id")
+	userId := s.saltedUserId(googleId)
+
+	var expires sql.NullTime
+	q := `SELECT expires FROM bans WHERE user_id=$1 AND (expires IS NULL OR expires > NOW())`
+	err := s.store.QueryRowContext(ctx, q, userId).Scan(&expires)
+	if err == sql.ErrNoRows {
+		c.Next()
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	if expires.Valid {
+		panic(suspendedUser)
+	}
+	panic(bannedUser)
+}
+
+// Bans a user or suspends them for a number of hours
+func (s *Server) banHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	moderator, _ := c.Get("email")
+	userId := strings.TrimSpace(c.DefaultPostForm("userId", ""))
+	reason := strings.TrimSpace(c.DefaultPostForm("reason", ""))
+	hideArticles := c.DefaultPostForm("hideArticles", "false") == "true"
+
+	// Check validity of user id, reason and duration
+	if len(userId) != 40 || len(reason) > maxBanReasonLength {
+		panic(invalidBan)
+	}
+	hours, err := strconv.Atoi(c.DefaultPostForm("hours", "0"))
+	if err != nil || hours < 0 {
+		panic(invalidNumber)
+	}
+	var expires sql.NullTime
+	if hours > 0 {
+		expires = sql.NullTime{Time: s.clock.Now().Add(time.Duration(hours) * time.Hour), Valid: true}
+	}
+
+	googleId, err := s.resolveGoogleId(ctx, userId)
+	if err != nil {
+		panic(err)
+	}
+
+	q := `INSERT INTO bans (user_id, google_id, reason, expires, hide_articles, banned_by) VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (user_id) DO UPDATE SET google_id = COALESCE(EXCLUDED.google_id, bans.google_id), reason = EXCLUDED.reason,
+	expires = EXCLUDED.expires, hide_articles = EXCLUDED.hide_articles, banned_by = EXCLUDED.banned_by, created = NOW()`
+	_, err = s.store.ExecContext(ctx, q, userId, googleId, reason, expires, hideArticles, moderator)
+	if err != nil {
+		panic(err)
+	}
+
+	// Articles of the user may appear or disappear, without knowing which ones are cached
+	s.invalidateArticles(ctx)
+	c.JSON(201, banResponse{userId, nullTime(expires), hideArticles})
+}
+
+// Lifts the ban of a user
+func (s *Server) unbanHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	userId := c.Param("userId")
+	result, err := s.store.ExecContext(ctx, `DELETE FROM bans WHERE user_id=$1`, userId)
+	if err != nil {
+		panic(err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		panic(notFound)
+	}
+
+	s.invalidateArticles(ctx)
+	c.JSON(200, userIdResponse{userId})
+}
+
+// Responds with all bans and suspensions that have not expired
+func (s *Server) bansHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	q := `SELECT user_id, reason, expires, hide_articles, appeal_note, banned_by, created FROM bans
+	WHERE expires IS NULL OR expires > NOW()
+	ORDER BY created DESC`
+	rows, err := s.store.QueryContext(ctx, q)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	bans := []ban{}
+	for rows.Next() {
+		var userId string
+		var reason string
+		var expires sql.NullTime
+		var hideArticles bool
+		var appealNote string
+		var bannedBy string
+		var created time.Time
+		err = rows.Scan(&userId, &reason, &expires, &hideArticles, &appealNote, &bannedBy, &created)
+		if err != nil {
+			panic(err)
+		}
+		bans = append(bans, ban{userId, reason, nullTime(expires), hideArticles, appealNote, bannedBy, created})
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	c.JSON(200, bansResponse{len(bans), bans})
+}
+
+// Responds with the ban of the user (if any)
+func (s *Server) userBanHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	googleId, _ := c.Get("id")
+
+	var reason string
+	var expires sql.NullTime
+	var appealNote string
+	q := `SELECT reason, expires, appeal_note FROM bans WHERE user_id=$1 AND (expires IS NULL OR expires > NOW())`
+	err := s.store.QueryRowContext(ctx, q, s.saltedUserId(googleId)).Scan(&reason, &expires, &appealNote)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			panic(notFound)
+		} else {
+			panic(err)
+		}
+	}
+
+	c.JSON(200, userBanResponse{reason, nullTime(expires), appealNote})
+}
+
+// Lets a banned user appeal their ban with a note
+func (s *Server) appealBanHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	googleId, _ := c.Get("id")
+	note := strings.TrimSpace(c.DefaultPostForm("note", ""))
+	if note == "" || len(note) > maxAppealNoteLength {
+		panic(invalidBan)
+	}
+
+	q := `UPDATE bans SET appeal_note=$1 WHERE user_id=$2 AND (expires IS NULL OR expires > NOW())`
+	result, err := s.store.ExecContext(ctx, q, note, s.saltedUserId(googleId))
+	if err != nil {
+		panic(err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		panic(notFound)
+	}
+
+	c.JSON(200, appealResponse{note})
+}
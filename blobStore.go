@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Storage for uploaded images
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	Ping(ctx context.Context) error
+}
+
+// Blob store backed by an s3 bucket
+type s3BlobStore struct {
+	session *session.Session
+	bucket  string
+}
+
+func newS3BlobStore(session *session.Session, bucket string) *s3BlobStore {
+	return &s3BlobStore{session, bucket}
+}
+
+func (b *s3BlobStore) Put(ctx context.Context, key string, body io.Reader) error {
+	uploader := s3manager.NewUploader(b.session)
+	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	})
+	return err
+}
+
+func (b *s3BlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	buffer := aws.NewWriteAtBuffer([]byte{})
+	downloader := s3manager.NewDownloader(b.session)
+	_, err := downloader.DownloadWithContext(ctx, buffer, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (b *s3BlobStore) Delete(ctx context.Context, key string) error {
+	_, err := s3.New(b.session).DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// Checks that the bucket exists and we may access it
+func (b *s3BlobStore) Ping(ctx context.Context) error {
+	_, err := s3.New(b.session).HeadBucketWithContext(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(b.bucket),
+	})
+	return err
+}
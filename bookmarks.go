@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bookmarks an article, or moves the bookmark to another folder
+func (s *Server) bookmarkHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	articleId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || articleId < 0 {
+		panic(invalidNumber)
+	}
+	var req bookmarkRequest
+	fields := bindRequest(c, &req)
+	if len(fields) > 0 {
+		panic(fields)
+	}
+	folder := strings.TrimSpace(req.Folder)
+	userId := c.GetString("id")
+
+	// Only visible articles can be bookmarked, bookmarks of articles hidden later are kept
+	var exists bool
+	q := `SELECT exists(SELECT 1 FROM articles WHERE id=$1 AND ` + visibleArticleSql + `) AS "exists"`
+	err = s.store.QueryRowContext(ctx, q, articleId).Scan(&exists)
+	if err != nil {
+		panic(err)
+	}
+	if !exists {
+		panic(notFound)
+	}
+
+	q = `INSERT INTO bookmarks (user_id, article_id, folder) VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, article_id) DO UPDATE SET folder = EXCLUDED.folder`
+	_, err = s.store.ExecContext(ctx, q, userId, articleId, folder)
+	if err != nil {
+		panic(err)
+	}
+
+	c.JSON(200, bookmarkResponse{true, folder})
+}
+
+// Removes the bookmark of an article, succeeds if there is none
+func (s *Server) unbookmarkHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	articleId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || articleId < 0 {
+		panic(invalidNumber)
+	}
+
+	q := `DELETE FROM bookmarks WHERE user_id=$1 AND article_id=$2`
+	_, err = s.store.ExecContext(ctx, q, c.GetString("id"), articleId)
+	if err != nil {
+		panic(err)
+	}
+
+	c.JSON(200, bookmarkResponse{false, ""})
+}
+
+// Responds with the bookmarked articles of the user, most recently bookmarked first
+// The folder query parameter (empty for bookmarks without a folder) restricts them to a folder
+func (s *Server) bookmarksHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	// Check validity of limit and offset
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > s.config.SearchMaxLimit {
+		panic(invalidNumber)
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		panic(invalidNumber)
+	}
+
+	args := []interface{}{c.GetString("id"), limit, offset}
+	folderSql := ""
+	if folder, ok := c.GetQuery("folder"); ok {
+		args = append(args, strings.TrimSpace(folder))
+		folderSql = ` AND bookmarks.folder = $4`
+	}
+
+	// Bookmarks of hidden or deleted articles are kept but not listed, they come back if the article does
+	q := `SELECT articles.id, articles.author, articles.image_url, articles.title, articles.tags, articles.views, articles.hearts, articles.created
+	FROM bookmarks JOIN articles ON articles.id = bookmarks.article_id
+	WHERE bookmarks.user_id = $1` + folderSql + ` AND ` + visibleArticleSql + `
+	ORDER BY bookmarks.created DESC, articles.id DESC LIMIT $2 OFFSET $3`
+	articles, err := s.loadArticleList(ctx, q, args...)
+	if err != nil {
+		panic(err)
+	}
+
+	c.JSON(200, articles)
+}
+
+// Responds with the bookmark folders of the user and the number of visible articles in each
+func (s *Server) bookmarkFoldersHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	q := `SELECT bookmarks.folder, COUNT(*) FROM bookmarks JOIN articles ON articles.id = bookmarks.article_id
+	WHERE bookmarks.user_id = $1 AND ` + visibleArticleSql + `
+	GROUP BY bookmarks.folder ORDER BY bookmarks.folder`
+	rows, err := s.store.QueryContext(ctx, q, c.GetString("id"))
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	folders := []bookmarkFolder{}
+	for rows.Next() {
+		var folder bookmarkFolder
+		err = rows.Scan(&folder.Folder, &folder.Count)
+		if err != nil {
+			panic(err)
+		}
+		folders = append(folders, folder)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	c.JSON(200, bookmarkFoldersResponse{folders})
+}
+
+// Bookmark state of an article for a user
+func (s *Server) loadBookmark(ctx context.Context, userId string, articleId int) (*bookmarkResponse, error) {
+	bookmark := &bookmarkResponse{}
+	q := `SELECT folder FROM bookmarks WHERE user_id=$1 AND article_id=$2`
+	err := s.store.QueryRowContext(ctx, q, userId, articleId).Scan(&bookmark.Folder)
+	if err == sql.ErrNoRows {
+		return bookmark, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	bookmark.Bookmarked = true
+	return bookmark, nil
+}
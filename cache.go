@@ -0,0 +1,252 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// How long cached values live, invalidation usually removes them sooner
+const (
+	articleCacheTTL = time.Minute
+	tagsCacheTTL    = time.Hour
+	listingCacheTTL = 30 * time.Second
+	generationTTL   = 24 * time.Hour // forgetting a generation is safe, it only invalidates early
+)
+
+// Generations are part of the keys of cached values, changing one invalidates every value keyed with it
+// Unlike deleting a key this also discards values of loads that were running during the change
+const (
+	articlesGeneration = "articles" // any article may have changed (ex. all articles of a banned user were hidden)
+	listingsGeneration = "listings" // any listing may have changed (ex. an article was created or hidden)
+)
+
+// Key value cache for json encoded responses, values expire after their ttl
+// Implementations may forget values early, a missing value is never an error
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Close() error
+}
+
+// Creates the cache named by the CACHE_STORE option
+func newCache(config *Config, clock Clock) Cache {
+	switch config.CacheStore {
+	case "redis":
+		return newRedisCache(config.RedisUrl)
+	case "none":
+		return noCache{}
+	}
+	return newMemoryCache(config.CacheSize, clock)
+}
+
+type cacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time // zero for values that do not expire
+}
+
+// Least recently used cache holding up to size values
+// Only for single instance deployments, other instances would not see invalidations
+type memoryCache struct {
+	clock   Clock
+	size    int
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+}
+
+func newMemoryCache(size int, clock Clock) *memoryCache {
+	return &memoryCache{clock: clock, size: size, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := element.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && !c.clock.Now().Before(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+func (c *memoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = c.clock.Now().Add(ttl)
+	}
+	if element, ok := c.entries[key]; ok {
+		element.Value = &cacheEntry{key, value, expires}
+		c.order.MoveToFront(element)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key, value, expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+	return nil
+}
+
+func (c *memoryCache) Close() error {
+	return nil
+}
+
+// Cache shared by all instances, in redis or any server speaking its protocol
+type redisCache struct {
+	client *redis.Client
+}
+
+const redisKeyPrefix = "crowdreport:"
+
+func newRedisCache(url string) *redisCache {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		panic(err) // checked by Config.validate
+	}
+	return &redisCache{redis.NewClient(options)}
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := c.client.Get(ctx, redisKeyPrefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, redisKeyPrefix+key, value, ttl).Err()
+}
+
+func (c *redisCache) Close() error {
+	return c.client.Close()
+}
+
+// Cache that never holds anything
+type noCache struct{}
+
+func (noCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	return nil, false, nil
+}
+
+func (noCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return nil
+}
+
+func (noCache) Close() error {
+	return nil
+}
+
+// Current value of a generation
+// A forgotten generation is replaced by a new one, which safely invalidates everything keyed with the old one
+func (s *Server) generation(ctx context.Context, name string) string {
+	key := "generation:" + name
+	value, ok, err := s.cache.Get(ctx, key)
+	if err != nil {
+		s.logger.Warn("failed to read cache", "key", key, "error", err)
+	}
+	if ok {
+		return string(value)
+	}
+	return s.bumpGeneration(ctx, name)
+}
+
+// Replaces a generation with a new one, never reusing an old value
+func (s *Server) bumpGeneration(ctx context.Context, name string) string {
+	key := "generation:" + name
+	b := make([]byte, 8)
+	rand.Read(b)
+	value := hex.EncodeToString(b)
+	err := s.cache.Set(ctx, key, []byte(value), generationTTL)
+	if err != nil {
+		s.logger.Warn("failed to write cache", "key", key, "error", err)
+	}
+	return value
+}
+
+func (s *Server) articleCacheKey(ctx context.Context, id int) string {
+	return fmt.Sprintf("article:%d:%s:%s", id, s.generation(ctx, articlesGeneration), s.generation(ctx, articleGeneration(id)))
+}
+
+func articleGeneration(id int) string {
+	return "article:" + strconv.Itoa(id)
+}
+
+// Gets the cached value of key into v, calling load and caching its result on a miss
+// Concurrent misses of the same key share a single load, cache failures fall back to loading
+func (s *Server) cached(ctx context.Context, key string, ttl time.Duration, v interface{}, load func(ctx context.Context) (interface{}, error)) error {
+	data, ok, err := s.cache.Get(ctx, key)
+	if err != nil {
+		s.logger.Warn("failed to read cache", "key", key, "error", err)
+	}
+	if ok {
+		cacheRequests.WithLabelValues("hit").Inc()
+		return json.Unmarshal(data, v)
+	}
+	cacheRequests.WithLabelValues("miss").Inc()
+
+	result, err, _ := s.loads.Do(key, func() (interface{}, error) {
+		// Waiting requests share the load, so it must outlive the request that started it
+		ctx := context.WithoutCancel(ctx)
+		value, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		err = s.cache.Set(ctx, key, data, ttl)
+		if err != nil {
+			s.logger.Warn("failed to write cache", "key", key, "error", err)
+		}
+		return data, nil
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(result.([]byte), v)
+}
+
+// Forgets the cached copy of an article and every listing that may contain it
+// For changes of its content or visibility, listings with it may have to gain or lose it
+func (s *Server) invalidateArticle(ctx context.Context, id int) {
+	s.bumpGeneration(ctx, articleGeneration(id))
+	s.bumpGeneration(ctx, listingsGeneration)
+}
+
+// Forgets the cached copy of an article whose counts (ex. hearts) changed
+// Listings only show or sort by the counts, they catch up within listingCacheTTL
+func (s *Server) invalidateArticleCounts(ctx context.Context, id int) {
+	s.bumpGeneration(ctx, articleGeneration(id))
+}
+
+// Forgets every cached article and listing
+func (s *Server) invalidateArticles(ctx context.Context) {
+	s.bumpGeneration(ctx, articlesGeneration)
+	s.bumpGeneration(ctx, listingsGeneration)
+}
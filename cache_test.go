@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	t.Setenv("CACHE_SIZE", "2")
+	cache := newCache(testConfig(t), newFakeClock())
+	ctx := context.Background()
+
+	cache.Set(ctx, "a", []byte("a"), time.Minute)
+	cache.Set(ctx, "b", []byte("b"), time.Minute)
+	cache.Get(ctx, "a") // b is now the least recently used
+	cache.Set(ctx, "c", []byte("c"), time.Minute)
+
+	for key, held := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok, _ := cache.Get(ctx, key); ok != held {
+			t.Errorf("%s held %v, want %v", key, ok, held)
+		}
+	}
+
+	// Replacing a value does not evict another one
+	cache.Set(ctx, "c", []byte("c2"), time.Minute)
+	value, ok, _ := cache.Get(ctx, "c")
+	if _, held, _ := cache.Get(ctx, "a"); !ok || string(value) != "c2" || !held {
+		t.Errorf("replaced c to %q (%v), a held %v", value, ok, held)
+	}
+}
+
+func TestMemoryCacheExpires(t *testing.T) {
+	clock := newFakeClock()
+	cache := newMemoryCache(10, clock)
+	ctx := context.Background()
+
+	cache.Set(ctx, "short", []byte("short"), time.Minute)
+	cache.Set(ctx, "forever", []byte("forever"), 0)
+
+	clock.Advance(time.Minute - time.Second)
+	if _, ok, _ := cache.Get(ctx, "short"); !ok {
+		t.Error("value expired before its ttl")
+	}
+	clock.Advance(time.Second)
+	if _, ok, _ := cache.Get(ctx, "short"); ok {
+		t.Error("value outlived its ttl")
+	}
+	if len(cache.entries) != 1 || cache.order.Len() != 1 {
+		t.Errorf("expired value still held, %d entries", len(cache.entries))
+	}
+
+	clock.Advance(365 * 24 * time.Hour)
+	if _, ok, _ := cache.Get(ctx, "forever"); !ok {
+		t.Error("value without ttl expired")
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	article := s.articleCacheKey(ctx, 1)
+	other := s.articleCacheKey(ctx, 2)
+	listings := s.generation(ctx, listingsGeneration)
+
+	s.invalidateArticleCounts(ctx, 1)
+	if s.articleCacheKey(ctx, 1) == article || s.generation(ctx, listingsGeneration) != listings {
+		t.Error("changed counts should only invalidate the article")
+	}
+	article = s.articleCacheKey(ctx, 1)
+
+	s.invalidateArticle(ctx, 2)
+	if s.articleCacheKey(ctx, 1) != article || s.articleCacheKey(ctx, 2) == other || s.generation(ctx, listingsGeneration) == listings {
+		t.Error("a changed article should invalidate itself and the listings only")
+	}
+	other = s.articleCacheKey(ctx, 2)
+	listings = s.generation(ctx, listingsGeneration)
+
+	s.invalidateArticles(ctx)
+	if s.articleCacheKey(ctx, 1) == article || s.articleCacheKey(ctx, 2) == other || s.generation(ctx, listingsGeneration) == listings {
+		t.Error("invalidating all articles should invalidate every article and listing")
+	}
+}
+
+func TestCachedReloadsAfterInvalidation(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	loads := 0
+	load := func(ctx context.Context) (interface{}, error) {
+		loads++
+		return loads, nil
+	}
+
+	var value int
+	for i := 0; i < 2; i++ {
+		err := s.cached(ctx, s.articleCacheKey(ctx, 1), articleCacheTTL, &value, load)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loads != 1 || value != 1 {
+		t.Fatalf("%d loads, value %d, want the second read cached", loads, value)
+	}
+
+	s.invalidateArticleCounts(ctx, 1)
+	err := s.cached(ctx, s.articleCacheKey(ctx, 1), articleCacheTTL, &value, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loads != 2 || value != 2 {
+		t.Errorf("%d loads, value %d, want a reload after invalidation", loads, value)
+	}
+}
+
+// Cache signalling every miss, so that tests know when concurrent readers are about to load
+type missSignallingCache struct {
+	Cache
+	misses sync.WaitGroup
+}
+
+func (c *missSignallingCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, ok, err := c.Cache.Get(ctx, key)
+	if !ok {
+		c.misses.Done()
+	}
+	return value, ok, err
+}
+
+func TestCachedSharesConcurrentMisses(t *testing.T) {
+	const readers = 10
+	s := newTestServer(t)
+	cache := &missSignallingCache{Cache: newMemoryCache(10, s.clock)}
+	cache.misses.Add(readers)
+	s.cache = cache
+
+	var loads int32
+	release := make(chan struct{})
+	load := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return "loaded", nil
+	}
+
+	var wg sync.WaitGroup
+	values := make([]string, readers)
+	errs := make([]error, readers)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.cached(context.Background(), "shared", time.Minute, &values[i], load)
+		}(i)
+	}
+	// Every reader missed, give the last ones time to join the load before it finishes
+	cache.misses.Wait()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if loads := atomic.LoadInt32(&loads); loads != 1 {
+		t.Errorf("%d loads, want one shared by all readers", loads)
+	}
+	for i := range values {
+		if errs[i] != nil || values[i] != "loaded" {
+			t.Errorf("reader %d got %q, %v", i, values[i], errs[i])
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	recaptchaVerifyUrl = "https://www.google.com/recaptcha/api/siteverify"
+	hcaptchaVerifyUrl  = "https://hcaptcha.com/siteverify"
+	turnstileVerifyUrl = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+)
+
+// Returned (wrapped) by a CaptchaVerifier when the captcha itself was not accepted
+var errCaptchaRejected = errors.New("captcha rejected")
+
+// Verifies a captcha token solved by a client for a given action
+type CaptchaVerifier interface {
+	Verify(ctx context.Context, token string, remoteIp string, action string) (*CaptchaResult, error)
+}
+
+// Verification response shared by reCAPTCHA, hCaptcha and Turnstile
+type CaptchaResult struct {
+	Success    bool     `json:"success"`
+	Score      *float64 `json:"score"` // only sent by reCAPTCHA v3 and hCaptcha enterprise
+	Action     string   `json:"action"`
+	Hostname   string   `json:"hostname"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+// Verifier for providers speaking the siteverify protocol
+type siteVerifyCaptcha struct {
+	verifyUrl  string
+	secret     string
+	thresholds map[string]float64 // minimum score per action, "default" applies to all others
+	hostnames  []string           // accepted hostnames, empty accepts all
+	client     *http.Client
+}
+
+func newCaptchaVerifier(provider string, secret string, thresholds map[string]float64, hostnames []string) (CaptchaVerifier, error) {
+	verifyUrls := map[string]string{
+		"recaptcha": recaptchaVerifyUrl,
+		"hcaptcha":  hcaptchaVerifyUrl,
+		"turnstile": turnstileVerifyUrl,
+	}
+	verifyUrl, ok := verifyUrls[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown captcha provider %q", provider)
+	}
+	return &siteVerifyCaptcha{
+		verifyUrl:  verifyUrl,
+		secret:     secret,
+		thresholds: thresholds,
+		hostnames:  hostnames,
+		client:     newTracedHttpClient(10 * time.Second),
+	}, nil
+}
+
+func (v *siteVerifyCaptcha) Verify(ctx context.Context, token string, remoteIp string, action string) (*CaptchaResult, error) {
+	if token == "" {
+		return nil, fmt.Errorf("%w: missing token", errCaptchaRejected)
+	}
+	form := url.Values{
+		"secret":   {v.secret},
+		"response": {token},
+		"remoteip": {remoteIp},
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", v.verifyUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := v.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("captcha verification failed with status %d", response.StatusCode)
+	}
+
+	var result CaptchaResult
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, v.check(&result, action)
+}
+
+// Checks a verification response against the expected action, score and hostname
+func (v *siteVerifyCaptcha) check(result *CaptchaResult, action string) error {
+	if !result.Success {
+		return fmt.Errorf("%w: %s", errCaptchaRejected, strings.Join(result.ErrorCodes, ","))
+	}
+	if result.Action != "" && action != "" && result.Action != action {
+		return fmt.Errorf("%w: action %q does not match %q", errCaptchaRejected, result.Action, action)
+	}
+	if result.Score != nil {
+		threshold, ok := v.thresholds[action]
+		if !ok {
+			threshold = v.thresholds["default"]
+		}
+		if *result.Score < threshold {
+			return fmt.Errorf("%w: score %.2f below %.2f", errCaptchaRejected, *result.Score, threshold)
+		}
+	}
+	if len(v.hostnames) > 0 {
+		for _, hostname := range v.hostnames {
+			if result.Hostname == hostname {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: hostname %q not accepted", errCaptchaRejected, result.Hostname)
+	}
+	return nil
+}
+
+// Parses per action score thresholds (ex. "default=0.5,create=0.7")
+func parseCaptchaThresholds(thresholdsString string) (map[string]float64, error) {
+	thresholds := map[string]float64{"default": 0.5}
+	if thresholdsString == "" {
+		return thresholds, nil
+	}
+	for _, pair := range strings.Split(thresholdsString, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid captcha threshold %q", pair)
+		}
+		threshold, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			return nil, fmt.Errorf("invalid captcha threshold %q", pair)
+		}
+		thresholds[strings.TrimSpace(parts[0])] = threshold
+	}
+	return thresholds, nil
+}
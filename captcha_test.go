@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSiteVerifyCaptcha(t *testing.T) {
+	cases := []struct {
+		name       string
+		status     int
+		response   string
+		action     string
+		thresholds map[string]float64
+		hostnames  []string
+		rejected   bool // errCaptchaRejected
+		failed     bool // any other error
+	}{
+		{name: "success without score", response: `{"success": true, "hostname": "crowdreport.me"}`, action: "create"},
+		{name: "success above default threshold", response: `{"success": true, "score": 0.9, "action": "create"}`, action: "create"},
+		{name: "failure", response: `{"success": false, "error-codes": ["invalid-input-response"]}`, action: "create", rejected: true},
+		{name: "action mismatch", response: `{"success": true, "score": 0.9, "action": "login"}`, action: "create", rejected: true},
+		{name: "score below default threshold", response: `{"success": true, "score": 0.3, "action": "create"}`, action: "create", rejected: true},
+		{name: "score at default threshold", response: `{"success": true, "score": 0.5, "action": "create"}`, action: "create"},
+		{
+			name:       "score below action threshold",
+			response:   `{"success": true, "score": 0.6, "action": "create"}`,
+			action:     "create",
+			thresholds: map[string]float64{"default": 0.5, "create": 0.7},
+			rejected:   true,
+		},
+		{
+			name:       "score above action threshold of another action",
+			response:   `{"success": true, "score": 0.6, "action": "heart"}`,
+			action:     "heart",
+			thresholds: map[string]float64{"default": 0.5, "create": 0.7},
+		},
+		{name: "accepted hostname", response: `{"success": true, "hostname": "crowdreport.me"}`, hostnames: []string{"www.crowdreport.me", "crowdreport.me"}},
+		{name: "unknown hostname", response: `{"success": true, "hostname": "evil.example"}`, hostnames: []string{"crowdreport.me"}, rejected: true},
+		{name: "provider error", status: http.StatusInternalServerError, response: `{}`, failed: true},
+		{name: "invalid json", response: `not json`, failed: true},
+	}
+
+	for _, provider := range []string{"recaptcha", "hcaptcha", "turnstile"} {
+		for _, tc := range cases {
+			t.Run(provider+"/"+tc.name, func(t *testing.T) {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					r.ParseForm()
+					if r.PostForm.Get("secret") != "secret" || r.PostForm.Get("response") != "token" || r.PostForm.Get("remoteip") != "10.0.0.1" {
+						t.Errorf("unexpected verification request %v", r.PostForm)
+					}
+					status := tc.status
+					if status == 0 {
+						status = http.StatusOK
+					}
+					w.WriteHeader(status)
+					fmt.Fprint(w, tc.response)
+				}))
+				defer server.Close()
+
+				thresholds := tc.thresholds
+				if thresholds == nil {
+					thresholds, _ = parseCaptchaThresholds("")
+				}
+				verifier, err := newCaptchaVerifier(provider, "secret", thresholds, tc.hostnames)
+				if err != nil {
+					t.Fatal(err)
+				}
+				verifier.(*siteVerifyCaptcha).verifyUrl = server.URL
+
+				_, err = verifier.Verify(context.Background(), "token", "10.0.0.1", tc.action)
+				switch {
+				case tc.rejected && !errors.Is(err, errCaptchaRejected):
+					t.Fatalf("error %v, want rejected", err)
+				case tc.failed && (err == nil || errors.Is(err, errCaptchaRejected)):
+					t.Fatalf("error %v, want a verification failure", err)
+				case !tc.rejected && !tc.failed && err != nil:
+					t.Fatalf("error %v, want accepted", err)
+				}
+			})
+		}
+	}
+}
+
+func TestSiteVerifyCaptchaRejectsMissingToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("missing token was sent to the provider")
+	}))
+	defer server.Close()
+
+	verifier, err := newCaptchaVerifier("recaptcha", "secret", map[string]float64{"default": 0.5}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.(*siteVerifyCaptcha).verifyUrl = server.URL
+	_, err = verifier.Verify(context.Background(), "", "10.0.0.1", "create")
+	if !errors.Is(err, errCaptchaRejected) {
+		t.Fatalf("error %v, want rejected", err)
+	}
+}
+
+func TestParseCaptchaThresholds(t *testing.T) {
+	thresholds, err := parseCaptchaThresholds("create=0.7, heart=0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thresholds["default"] != 0.5 || thresholds["create"] != 0.7 || thresholds["heart"] != 0.3 {
+		t.Fatalf("unexpected thresholds %v", thresholds)
+	}
+	for _, invalid := range []string{"create", "create=high", "create=1.5", "create=-0.1"} {
+		_, err := parseCaptchaThresholds(invalid)
+		if err == nil {
+			t.Errorf("%q was accepted", invalid)
+		}
+	}
+}
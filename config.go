@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultConfigFile = "./secrets.env"
+
+// Configuration of the api, loaded once at startup
+type Config struct {
+	Port               string
+	TLSCertFile        string
+	TLSKeyFile         string
+	HTTP2              bool
+	ReadTimeout        time.Duration
+	ReadHeaderTimeout  time.Duration
+	WriteTimeout       time.Duration
+	IdleTimeout        time.Duration
+	ShutdownTimeout    time.Duration
+	MaxHeaderBytes     int
+	SignInUrl          string
+	StateSalt          string
+	GoogleIdSalt       string
+	GoogleClientId     string
+	GoogleClientSecret string
+	PsqlInfo           string
+	AutoMigrate        bool // apply pending migrations at startup
+	CorsOrigins        []string
+	TrustedProxies     []*net.IPNet // proxies whose X-Forwarded-For is believed, none by default
+	MetricsToken       string       // bearer token required by /metrics, empty serves metrics to anyone
+	LogLevel           slog.Level
+	LogFormat          string // json or text
+
+	TracingExporter    string // none, stdout or otlp
+	TracingSampleRatio float64
+
+	DBQueryTimeout    time.Duration
+	DBConnectRetry    time.Duration // how long to retry connecting at startup
+	DBMaxOpenConns    int
+	DBMaxIdleConns    int
+	DBConnMaxLifetime time.Duration
+	DBConnMaxIdleTime time.Duration
+
+	AwsRegion    string
+	AwsBucket    string
+	ImagePath    string         // public url prefix of uploaded images
+	ImageUrlRgx  *regexp.Regexp // matches urls of images in our image store, derived from ImagePath
+	MaxImageSize int64
+
+	CaptchaProvider   string
+	CaptchaSecret     string
+	CaptchaHostnames  []string
+	CaptchaThresholds map[string]float64
+
+	AdminEmail          string
+	ModeratorEmails     []string
+	ReportHideThreshold int
+	SpamHoldScore       float64
+	SpamRejectScore     float64
+
+	RestoreWindow     time.Duration
+	RetentionPeriod   time.Duration
+	PurgeInterval     time.Duration
+	ViewFlushInterval time.Duration
+	RankingInterval   time.Duration
+
+	RecommendationInterval time.Duration
+
+	RateLimits     map[string]rateLimit
+	RateLimitStore string
+
+	CacheStore string // memory, redis or none
+	CacheSize  int    // values held by the memory cache
+	RedisUrl   string
+
+	SearchMaxLimit       int
+	UserArticlesMaxLimit int
+	ModerationMaxLimit   int
+}
+
+// Reads typed values from the environment and remembers every invalid one
+type envReader struct {
+	errs []error
+}
+
+func (r *envReader) string(key string, def string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+func (r *envReader) list(key string, def []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func (r *envReader) int(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s must be an integer, got %q", key, value))
+	}
+	return i
+}
+
+func (r *envReader) float(key string, def float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s must be a number, got %q", key, value))
+	}
+	return f
+}
+
+func (r *envReader) duration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s must be a duration (ex. 1h30m), got %q", key, value))
+	}
+	return d
+}
+
+// Loads an env file into the environment, falling back to defaultConfigFile if it exists
+// Env vars set by the platform take precedence over the file
+func loadConfigFile(configFile string) error {
+	if configFile == "" {
+		if _, err := os.Stat(defaultConfigFile); err != nil {
+			return nil
+		}
+		configFile = defaultConfigFile
+	}
+	err := godotenv.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config file %s: %w", configFile, err)
+	}
+	return nil
+}
+
+// Loads the configuration from flags, the environment and an optional env file (in that order of precedence)
+func loadConfig(args []string) (*Config, error) {
+	flags := flag.NewFlagSet("crowd-report-api", flag.ContinueOnError)
+	configFile := flags.String("config", "", "env file to load (defaults to "+defaultConfigFile+" if it exists)")
+	port := flags.String("port", "", "port to listen on (overrides PORT)")
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadConfigFile(*configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &envReader{}
+	cfg := &Config{
+		Port:               env.string("PORT", "5000"), // port env var is passed by aws
+		TLSCertFile:        env.string("TLS_CERT_FILE", ""),
+		TLSKeyFile:         env.string("TLS_KEY_FILE", ""),
+		HTTP2:              env.string("HTTP2", "true") == "true",
+		ReadTimeout:        env.duration("READ_TIMEOUT", 15*time.Second),
+		ReadHeaderTimeout:  env.duration("READ_HEADER_TIMEOUT", 5*time.Second),
+		WriteTimeout:       env.duration("WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:        env.duration("IDLE_TIMEOUT", 2*time.Minute),
+		ShutdownTimeout:    env.duration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		MaxHeaderBytes:     env.int("MAX_HEADER_BYTES", 1<<16),
+		SignInUrl:          env.string("SIGN_IN_URL", ""),
+		StateSalt:          env.string("STATE_SALT", ""),
+		GoogleIdSalt:       env.string("GOOGLE_ID_SALT", ""),
+		GoogleClientId:     env.string("GOOGLE_CLIENT_ID", ""),
+		GoogleClientSecret: env.string("GOOGLE_CLIENT_SECRET", ""),
+		PsqlInfo:           env.string("PSQL_INFO", ""),
+		AutoMigrate:        env.string("AUTO_MIGRATE", "false") == "true",
+		MetricsToken:       env.string("METRICS_TOKEN", ""),
+		LogFormat:          env.string("LOG_FORMAT", "json"),
+		TracingExporter:    env.string("TRACING_EXPORTER", "none"),
+		TracingSampleRatio: env.float("TRACING_SAMPLE_RATIO", 1),
+		CorsOrigins:        env.list("CORS_ORIGINS", []string{"http://localhost:8080", "https://www.crowdreport.me", "https://www.google.com"}),
+
+		DBQueryTimeout:    env.duration("DB_QUERY_TIMEOUT", 5*time.Second),
+		DBConnectRetry:    env.duration("DB_CONNECT_RETRY", 30*time.Second),
+		DBMaxOpenConns:    env.int("DB_MAX_OPEN_CONNS", 20),
+		DBMaxIdleConns:    env.int("DB_MAX_IDLE_CONNS", 5),
+		DBConnMaxLifetime: env.duration("DB_CONN_MAX_LIFETIME", 30*time.Minute),
+		DBConnMaxIdleTime: env.duration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute),
+
+		AwsRegion:    env.string("AWS_REGION", "us-west-1"),
+		AwsBucket:    env.string("AWS_S3_BUCKET", ""),
+		ImagePath:    env.string("IMAGE_PATH", "https://api.crowdreport.me/images/"),
+		MaxImageSize: int64(env.int("MAX_IMAGE_SIZE", 500000)),
+
+		CaptchaProvider:  env.string("CAPTCHA_PROVIDER", "recaptcha"),
+		CaptchaSecret:    env.string("CAPTCHA_SECRET", os.Getenv("RECAPTCHA_SECRET")),
+		CaptchaHostnames: env.list("CAPTCHA_HOSTNAMES", nil),
+
+		AdminEmail:          env.string("ADMIN_EMAIL", ""),
+		ModeratorEmails:     env.list("MODERATOR_EMAILS", nil),
+		ReportHideThreshold: env.int("REPORT_HIDE_THRESHOLD", 5),
+		SpamHoldScore:       env.float("SPAM_HOLD_SCORE", 1),
+		SpamRejectScore:     env.float("SPAM_REJECT_SCORE", 3),
+
+		RestoreWindow:     time.Duration(env.int("RESTORE_WINDOW_DAYS", 30)) * 24 * time.Hour,
+		RetentionPeriod:   time.Duration(env.int("RETENTION_DAYS", 30)) * 24 * time.Hour,
+		PurgeInterval:     env.duration("PURGE_INTERVAL", time.Hour),
+		ViewFlushInterval: env.duration("VIEW_FLUSH_INTERVAL", 10*time.Second),
+		RankingInterval:   env.duration("RANKING_INTERVAL", 10*time.Minute),
+
+		RecommendationInterval: env.duration("RECOMMENDATION_INTERVAL", 0),
+
+		RateLimitStore: env.string("RATE_LIMIT_STORE", "memory"),
+
+		CacheStore: env.string("CACHE_STORE", "memory"),
+		CacheSize:  env.int("CACHE_SIZE", 10000),
+		RedisUrl:   env.string("REDIS_URL", ""),
+
+		SearchMaxLimit:       env.int("SEARCH_MAX_LIMIT", 16),
+		UserArticlesMaxLimit: env.int("USER_ARTICLES_MAX_LIMIT", 10),
+		ModerationMaxLimit:   env.int("MODERATION_MAX_LIMIT", 100),
+	}
+	if *port != "" {
+		cfg.Port = *port
+	}
+
+	errs := env.errs
+	cfg.CaptchaThresholds, err = parseCaptchaThresholds(env.string("CAPTCHA_SCORE_THRESHOLDS", ""))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("CAPTCHA_SCORE_THRESHOLDS: %w", err))
+	}
+	cfg.RateLimits, err = parseRateLimits(env.string("RATE_LIMITS", defaultRateLimits))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("RATE_LIMITS: %w", err))
+	}
+	cfg.TrustedProxies, err = parseTrustedProxies(env.list("TRUSTED_PROXIES", nil))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("TRUSTED_PROXIES: %w", err))
+	}
+	cfg.LogLevel, err = parseLogLevel(env.string("LOG_LEVEL", "info"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: %w", err))
+	}
+	cfg.ImageUrlRgx = regexp.MustCompile(`^` + regexp.QuoteMeta(cfg.ImagePath) + `.+$`)
+
+	errs = append(errs, cfg.validate()...)
+	if len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = "  " + err.Error()
+		}
+		return nil, errors.New("invalid configuration:\n" + strings.Join(messages, "\n"))
+	}
+	return cfg, nil
+}
+
+// Checks that required values are set and values are in range
+func (cfg *Config) validate() []error {
+	var errs []error
+	required := [][2]string{
+		{"SIGN_IN_URL", cfg.SignInUrl},
+		{"STATE_SALT", cfg.StateSalt},
+		{"GOOGLE_ID_SALT", cfg.GoogleIdSalt},
+		{"GOOGLE_CLIENT_ID", cfg.GoogleClientId},
+		{"GOOGLE_CLIENT_SECRET", cfg.GoogleClientSecret},
+		{"PSQL_INFO", cfg.PsqlInfo},
+		{"AWS_S3_BUCKET", cfg.AwsBucket},
+		{"CAPTCHA_SECRET (or RECAPTCHA_SECRET)", cfg.CaptchaSecret},
+	}
+	for _, pair := range required {
+		if pair[1] == "" {
+			errs = append(errs, fmt.Errorf("%s is required", pair[0]))
+		}
+	}
+
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT must be a port number, got %q", cfg.Port))
+	}
+	if u, err := url.Parse(cfg.ImagePath); err != nil || u.Scheme == "" || u.Host == "" || !strings.HasSuffix(cfg.ImagePath, "/") {
+		errs = append(errs, fmt.Errorf("IMAGE_PATH must be an absolute url ending in /, got %q", cfg.ImagePath))
+	}
+	if cfg.SignInUrl != "" {
+		if u, err := url.Parse(cfg.SignInUrl); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("SIGN_IN_URL must be an absolute url, got %q", cfg.SignInUrl))
+		}
+	}
+	switch cfg.CaptchaProvider {
+	case "recaptcha", "hcaptcha", "turnstile":
+	default:
+		errs = append(errs, fmt.Errorf("CAPTCHA_PROVIDER must be recaptcha, hcaptcha or turnstile, got %q", cfg.CaptchaProvider))
+	}
+	if cfg.LogFormat != "json" && cfg.LogFormat != "text" {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be json or text, got %q", cfg.LogFormat))
+	}
+	switch cfg.TracingExporter {
+	case "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("TRACING_EXPORTER must be none, stdout or otlp, got %q", cfg.TracingExporter))
+	}
+	if cfg.TracingSampleRatio < 0 || cfg.TracingSampleRatio > 1 {
+		errs = append(errs, errors.New("TRACING_SAMPLE_RATIO must be between 0 and 1"))
+	}
+	if cfg.RateLimitStore != "memory" && cfg.RateLimitStore != "postgres" {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_STORE must be memory or postgres, got %q", cfg.RateLimitStore))
+	}
+	switch cfg.CacheStore {
+	case "memory", "none":
+	case "redis":
+		if _, err := redis.ParseURL(cfg.RedisUrl); err != nil {
+			errs = append(errs, fmt.Errorf("REDIS_URL must be a redis url when CACHE_STORE is redis: %w", err))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("CACHE_STORE must be memory, redis or none, got %q", cfg.CacheStore))
+	}
+	if cfg.CacheSize < 1 {
+		errs = append(errs, errors.New("CACHE_SIZE must be positive"))
+	}
+	if len(cfg.CorsOrigins) == 0 {
+		errs = append(errs, errors.New("CORS_ORIGINS must contain at least one origin"))
+	}
+	if cfg.MaxImageSize <= 0 {
+		errs = append(errs, errors.New("MAX_IMAGE_SIZE must be positive"))
+	}
+	if cfg.ReportHideThreshold < 0 {
+		errs = append(errs, errors.New("REPORT_HIDE_THRESHOLD must not be negative (0 disables auto hiding)"))
+	}
+	if cfg.SpamHoldScore <= 0 || cfg.SpamHoldScore > cfg.SpamRejectScore {
+		errs = append(errs, errors.New("SPAM_HOLD_SCORE must be positive and not above SPAM_REJECT_SCORE"))
+	}
+	if cfg.RestoreWindow < 0 || cfg.RetentionPeriod < cfg.RestoreWindow {
+		errs = append(errs, errors.New("RETENTION_DAYS must not be shorter than RESTORE_WINDOW_DAYS"))
+	}
+	if cfg.PurgeInterval <= 0 || cfg.ViewFlushInterval <= 0 || cfg.RankingInterval <= 0 {
+		errs = append(errs, errors.New("PURGE_INTERVAL, VIEW_FLUSH_INTERVAL and RANKING_INTERVAL must be positive"))
+	}
+	if cfg.RecommendationInterval < 0 {
+		errs = append(errs, errors.New("RECOMMENDATION_INTERVAL must not be negative"))
+	}
+	if cfg.ReadTimeout <= 0 || cfg.ReadHeaderTimeout <= 0 || cfg.WriteTimeout <= 0 || cfg.IdleTimeout <= 0 || cfg.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("READ_TIMEOUT, READ_HEADER_TIMEOUT, WRITE_TIMEOUT, IDLE_TIMEOUT and SHUTDOWN_TIMEOUT must be positive"))
+	}
+	if cfg.DBQueryTimeout <= 0 || cfg.DBConnectRetry < 0 || cfg.DBConnMaxLifetime < 0 || cfg.DBConnMaxIdleTime < 0 {
+		errs = append(errs, errors.New("DB_QUERY_TIMEOUT must be positive and DB_CONNECT_RETRY, DB_CONN_MAX_LIFETIME and DB_CONN_MAX_IDLE_TIME must not be negative"))
+	}
+	if cfg.DBMaxOpenConns < 1 || cfg.DBMaxIdleConns < 0 || cfg.DBMaxIdleConns > cfg.DBMaxOpenConns {
+		errs = append(errs, errors.New("DB_MAX_OPEN_CONNS must be positive and DB_MAX_IDLE_CONNS between 0 and DB_MAX_OPEN_CONNS"))
+	}
+	if cfg.MaxHeaderBytes < 4096 {
+		errs = append(errs, errors.New("MAX_HEADER_BYTES must be at least 4096"))
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	if cfg.SearchMaxLimit < 1 || cfg.UserArticlesMaxLimit < 1 || cfg.ModerationMaxLimit < 1 {
+		errs = append(errs, errors.New("SEARCH_MAX_LIMIT, USER_ARTICLES_MAX_LIMIT and MODERATION_MAX_LIMIT must be positive"))
+	}
+	return errs
+}
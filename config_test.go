@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigRefusesFakeCaptcha(t *testing.T) {
+	testConfig(t)
+	t.Setenv("CAPTCHA_PROVIDER", "fake")
+	t.Setenv("CAPTCHA_SECRET", "")
+	t.Setenv("RECAPTCHA_SECRET", "")
+
+	_, err := loadConfig(nil)
+	if err == nil {
+		t.Fatal("fake captcha provider was accepted")
+	}
+	for _, want := range []string{"CAPTCHA_PROVIDER", "CAPTCHA_SECRET"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s: %v", want, err)
+		}
+	}
+}
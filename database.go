@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Database the api reads and writes
+// Every call is bounded by the context of the request and the configured query timeout
+type Store interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error)
+	PingContext(ctx context.Context) error
+	// The same database with another query timeout, for jobs that outgrow DB_QUERY_TIMEOUT
+	WithTimeout(timeout time.Duration) Store
+	Close() error
+}
+
+// Rows releasing their query timeout when closed
+type Rows struct {
+	*sql.Rows
+	cancel context.CancelFunc
+}
+
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.cancel()
+	return err
+}
+
+// Row releasing its query timeout once scanned
+type Row struct {
+	*sql.Row
+	cancel context.CancelFunc
+	done   func(err error)
+}
+
+func (r *Row) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	err := r.Row.Scan(dest...)
+	r.done(err)
+	return err
+}
+
+// Transaction bounded as a whole by a single query timeout, released on commit or rollback
+// Its statements are traced and timed like those of the store, the timeout of the transaction bounds them
+type Tx struct {
+	*sql.Tx
+	cancel context.CancelFunc
+}
+
+func (t *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	ctx, done := instrumentQuery(ctx, queryOperation(query), query)
+	rows, err := t.Tx.QueryContext(ctx, query, args...)
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{rows, func() {}}, nil
+}
+
+func (t *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	ctx, done := instrumentQuery(ctx, queryOperation(query), query)
+	return &Row{t.Tx.QueryRowContext(ctx, query, args...), func() {}, done}
+}
+
+func (t *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := instrumentQuery(ctx, queryOperation(query), query)
+	result, err := t.Tx.ExecContext(ctx, query, args...)
+	done(err)
+	return result, err
+}
+
+func (t *Tx) Commit() error {
+	defer t.cancel()
+	return t.Tx.Commit()
+}
+
+func (t *Tx) Rollback() error {
+	defer t.cancel()
+	return t.Tx.Rollback()
+}
+
+// Starts the span and timer of a database call, done records the outcome
+func instrumentQuery(ctx context.Context, operation string, query string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := startQuerySpan(ctx, operation, query)
+	return ctx, func(err error) {
+		observeQuery(operation, start, err)
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+		endSpan(span, err)
+	}
+}
+
+// Store backed by a postgres connection pool
+type sqlStore struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func newSQLStore(db *sql.DB, timeout time.Duration) *sqlStore {
+	return &sqlStore{db, timeout}
+}
+
+func (s *sqlStore) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	ctx, done := instrumentQuery(ctx, queryOperation(query), query)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	done(err)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &Rows{rows, cancel}, nil
+}
+
+func (s *sqlStore) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	ctx, done := instrumentQuery(ctx, queryOperation(query), query)
+	return &Row{s.db.QueryRowContext(ctx, query, args...), cancel, done}
+}
+
+func (s *sqlStore) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	ctx, done := instrumentQuery(ctx, queryOperation(query), query)
+	result, err := s.db.ExecContext(ctx, query, args...)
+	done(err)
+	return result, err
+}
+
+func (s *sqlStore) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	_, done := instrumentQuery(ctx, "begin", "BEGIN")
+	tx, err := s.db.BeginTx(ctx, opts)
+	done(err)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &Tx{tx, cancel}, nil
+}
+
+func (s *sqlStore) PingContext(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlStore) WithTimeout(timeout time.Duration) Store {
+	return &sqlStore{s.db, timeout}
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// Opens the connection pool, retrying transient connection errors until retryFor has passed
+func connectToDB(psqlInfo string, retryFor time.Duration, logger *slog.Logger) (*sql.DB, error) {
+	db, err := sql.Open("postgres", psqlInfo)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(retryFor)
+	backoff := 500 * time.Millisecond
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return db, nil
+		}
+		if !isTransientDBError(err) || time.Now().Add(backoff).After(deadline) {
+			db.Close()
+			return nil, err
+		}
+		logger.Warn("failed to connect to database, retrying", "retryIn", backoff.String(), "error", err)
+		time.Sleep(backoff)
+		if backoff < 8*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// Whether a connection error may go away by itself (the database is starting or unreachable for now)
+// Errors reported by postgres itself, like a wrong password, are not worth retrying
+func isTransientDBError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// cannot_connect_now and too_many_connections
+		return pqErr.Code == "57P03" || pqErr.Code == "53300"
+	}
+	return true
+}
+
+// Applies the pool settings of the configuration and publishes pool statistics
+func configurePool(db *sql.DB, config *Config) {
+	db.SetMaxOpenConns(config.DBMaxOpenConns)
+	db.SetMaxIdleConns(config.DBMaxIdleConns)
+	db.SetConnMaxLifetime(config.DBConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.DBConnMaxIdleTime)
+	expvar.Publish("db", expvar.Func(func() interface{} {
+		return db.Stats()
+	}))
+	registerDBStats(db)
+}
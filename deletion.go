@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Marks an article as deleted so it can still be restored
+func softDeleteArticle(ctx context.Context, ex execer, articleId interface{}, deletedBy interface{}) error {
+	q := `UPDATE articles SET deleted_at = NOW(), deleted_by = $2 WHERE id=$1 AND deleted_at IS NULL`
+	_, err := ex.ExecContext(ctx, q, articleId, deletedBy)
+	return err
+}
+
+// Permanently deletes an article along with everything referencing it
+func purgeArticle(ctx context.Context, ex execer, articleId interface{}) error {
+	queries := []string{
+		`DELETE FROM hearts WHERE articleId=$1`,
+		`DELETE FROM article_media WHERE article_id=$1`,
+		`DELETE FROM reports WHERE article_id=$1`,
+		`DELETE FROM recommendations WHERE article_id=$1`,
+		`DELETE FROM bookmarks WHERE article_id=$1`,
+		`DELETE FROM articles WHERE id=$1`,
+	}
+	for _, q := range queries {
+		_, err := ex.ExecContext(ctx, q, articleId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restores a soft deleted article within the restore window
+func (s *Server) restoreArticleHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	userId, _ := c.Get("id")
+	email, _ := c.Get("email")
+
+	// Check validity of id
+	articleId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || articleId < 0 {
+		panic(invalidNumber)
+	}
+
+	// Check if deleted article exists
+	var authorGoogleId string
+	var deletedAt time.Time
+	var deletedBy sql.NullString
+	q := `SELECT author_google_id, deleted_at, deleted_by FROM articles WHERE id=$1 AND deleted_at IS NOT NULL`
+	err = s.store.QueryRowContext(ctx, q, articleId).Scan(&authorGoogleId, &deletedAt, &deletedBy)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			panic(notFound)
+		} else {
+			panic(err)
+		}
+	}
+
+	// Takedowns by moderators (through DELETE or a report) can only be undone by moderators
+	moderated := authorGoogleId != userId || s.isModerator(deletedBy.String)
+	if moderated && !s.isModerator(email) {
+		panic(noPermission)
+	}
+	if s.clock.Now().Sub(deletedAt) > s.config.RestoreWindow {
+		panic(restoreExpired)
+	}
+
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	q = `UPDATE articles SET deleted_at = NULL, deleted_by = NULL WHERE id=$1`
+	_, err = tx.ExecContext(ctx, q, articleId)
+	if err != nil {
+		panic(err)
+	}
+	if moderated {
+		err = logModeration(ctx, tx, email, articleId, nil, "restore", "")
+		if err != nil {
+			panic(err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	s.invalidateArticle(ctx, articleId)
+	c.JSON(200, articleIdResponse{articleId})
+}
+
+// Purges articles deleted longer than the retention period ago (run periodically)
+func (s *Server) purgeJob(ctx context.Context) error {
+	purged, err := s.purgeDeletedArticles(ctx)
+	if purged > 0 {
+		s.logger.Info("purged deleted articles", "count", purged)
+	}
+	return err
+}
+
+func (s *Server) purgeDeletedArticles(ctx context.Context) (int, error) {
+	q := `SELECT id FROM articles WHERE deleted_at < $1`
+	rows, err := s.store.QueryContext(ctx, q, s.clock.Now().Add(-s.config.RetentionPeriod))
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		images, err := s.articleImages(ctx, id)
+		if err != nil {
+			return i, err
+		}
+
+		tx, err := s.store.BeginTx(ctx, nil)
+		if err != nil {
+			return i, err
+		}
+		err = purgeArticle(ctx, tx, id)
+		if err != nil {
+			tx.Rollback()
+			return i, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return i, err
+		}
+
+		// Images are removed after the commit so a failed purge never leaves an article without images
+		for _, image := range images {
+			err = s.deleteUnusedImage(ctx, image)
+			if err != nil {
+				s.logger.Warn("failed to delete image", "image", image, "error", err)
+			}
+		}
+	}
+	return len(ids), nil
+}
+
+// Fetches the cover and gallery image urls of an article
+func (s *Server) articleImages(ctx context.Context, articleId int) ([]string, error) {
+	q := `SELECT image_url FROM articles WHERE id=$1
+	UNION SELECT url FROM article_media WHERE article_id=$1`
+	rows, err := s.store.QueryContext(ctx, q, articleId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []string
+	for rows.Next() {
+		var image string
+		err = rows.Scan(&image)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, rows.Err()
+}
+
+// Deletes an image from the image store unless another article still uses it, as cover, in its gallery or inline in its body
+func (s *Server) deleteUnusedImage(ctx context.Context, url string) error {
+	if !strings.HasPrefix(url, s.config.ImagePath) {
+		return nil
+	}
+	var used bool
+	q := `SELECT exists(SELECT 1 FROM articles WHERE image_url=$1
+	UNION SELECT 1 FROM article_media WHERE url=$1
+	UNION SELECT 1 FROM articles WHERE strpos(body, $2) > 0) AS "exists"`
+	err := s.store.QueryRowContext(ctx, q, url, `src="`+url+`"`).Scan(&used)
+	if err != nil || used {
+		return err
+	}
+	return s.blobs.Delete(ctx, strings.TrimPrefix(url, s.config.ImagePath))
+}
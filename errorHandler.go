@@ -14,31 +14,52 @@ type errorResponse struct {
 }
 
 var (
-	unknownError     = errorResponse{500, "Unknown Error", "An unknown error occured. Please try again later."}
-	invalidCode      = errorResponse{401, "Invalid Code", "Google did not accept the sign in code we sent it."}
-	invalidState     = errorResponse{401, "Invalid State", "The state provided did not match the state calculated."}
-	unverifiedEmail  = errorResponse{403, "Unverified Email", "Your google email is not verified."}
-	invalidToken     = errorResponse{401, "Invalid Token", "Your access token is invalid."}
-	invalidArticle   = errorResponse{400, "Invalid Article", "The article could not be created because it is invalid."}
-	noPermission     = errorResponse{403, "No Permission", "You do not have sufficient permission to perform the given action."}
-	notFound         = errorResponse{404, "Not Found", "The query did not find any records."}
-	invalidNumber    = errorResponse{400, "Invalid Number", "Number input was recieved wich was not a number or not in a valid range."}
-	fileTooLarge     = errorResponse{413, "File Too Large", "The file you tried to uplaod exceeded the maximum size."}
-	unacceptableMime = errorResponse{401, "Unacceptable Mime Type", "The mime type of the uploaded file was not accepted."}
-	invalidCaptcha   = errorResponse{401, "Invalid Captcha", "The captcha was not verified by google."}
+	unknownError      = errorResponse{500, "Unknown Error", "An unknown error occured. Please try again later."}
+	invalidCode       = errorResponse{401, "Invalid Code", "Google did not accept the sign in code we sent it."}
+	invalidState      = errorResponse{401, "Invalid State", "The state provided did not match the state calculated."}
+	unverifiedEmail   = errorResponse{403, "Unverified Email", "Your google email is not verified."}
+	invalidToken      = errorResponse{401, "Invalid Token", "Your access token is invalid."}
+	invalidArticle    = errorResponse{400, "Invalid Article", "The article could not be created because it is invalid."}
+	noPermission      = errorResponse{403, "No Permission", "You do not have sufficient permission to perform the given action."}
+	notFound          = errorResponse{404, "Not Found", "The query did not find any records."}
+	invalidNumber     = errorResponse{400, "Invalid Number", "Number input was recieved wich was not a number or not in a valid range."}
+	fileTooLarge      = errorResponse{413, "File Too Large", "The file you tried to uplaod exceeded the maximum size."}
+	unacceptableMime  = errorResponse{401, "Unacceptable Mime Type", "The mime type of the uploaded file was not accepted."}
+	invalidCaptcha    = errorResponse{401, "Invalid Captcha", "The captcha was not verified by google."}
+	invalidLocation   = errorResponse{400, "Invalid Location", "The location was not a valid latitude, longitude or bounding box."}
+	invalidReport     = errorResponse{400, "Invalid Report", "The report reason, action or note is not valid."}
+	restoreExpired    = errorResponse{410, "Restore Expired", "The article was deleted too long ago to be restored."}
+	invalidBan        = errorResponse{400, "Invalid Ban", "The user id, reason or appeal note is not valid."}
+	bannedUser        = errorResponse{403, "Banned", "Your account is banned from creating or changing content."}
+	suspendedUser     = errorResponse{403, "Suspended", "Your account is temporarily suspended from creating or changing content."}
+	tooManyRequests   = errorResponse{429, "Too Many Requests", "You are sending requests too quickly. Please try again later."}
+	spamDetected      = errorResponse{422, "Spam Detected", "The article was rejected by our spam filter."}
+	invalidBannedWord = errorResponse{400, "Invalid Banned Word", "A banned word must be a single word."}
+	invalidBody       = errorResponse{400, "Invalid Body", "The request body could not be read as json or form data."}
+	invalidFields     = errorResponse{422, "Invalid Fields", "Some fields of the request are missing or invalid."}
 )
 
 func handleError(c *gin.Context) {
 	if r := recover(); r != nil {
+		if fields, ok := r.(fieldErrors); ok {
+			c.Abort()
+			c.JSON(invalidFields.status, errorBody{
+				Name:      invalidFields.name,
+				Message:   invalidFields.message,
+				RequestId: c.GetString("requestId"),
+				Fields:    fields,
+			})
+			return
+		}
 		if reflect.TypeOf(r) != reflect.TypeOf(unknownError) {
-			fmt.Println("unexpected error")
-			fmt.Println(r)
+			requestLogger(c).Error("unexpected error", "error", fmt.Sprint(r), "path", c.Request.URL.Path)
 			r = unknownError
 		}
 		c.Abort()
-		c.JSON(r.(errorResponse).status, gin.H{
-			"name":    r.(errorResponse).name,
-			"message": r.(errorResponse).message,
+		c.JSON(r.(errorResponse).status, errorBody{
+			Name:      r.(errorResponse).name,
+			Message:   r.(errorResponse).message,
+			RequestId: c.GetString("requestId"),
 		})
 	}
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Answer of the scripted database to statements containing a fragment
+type scriptedResult struct {
+	fragment     string
+	columns      []string
+	rows         [][]driver.Value
+	rowsAffected int64
+	err          error
+}
+
+// A statement run against the scripted database
+type scriptedStatement struct {
+	query string
+	args  []driver.Value
+}
+
+// Database answering statements from a script and recording all of them, for a Store without postgres
+// Unscripted queries return no rows (sql.ErrNoRows for a single row), unscripted execs affect one row
+type scriptedDB struct {
+	mutex      sync.Mutex
+	results    []scriptedResult
+	statements []scriptedStatement
+}
+
+// Answers queries containing fragment with rows of columns, the first matching fragment wins
+func (db *scriptedDB) OnQuery(fragment string, columns []string, rows ...[]driver.Value) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.results = append(db.results, scriptedResult{fragment: fragment, columns: columns, rows: rows})
+}
+
+// Answers execs containing fragment with a number of affected rows
+func (db *scriptedDB) OnExec(fragment string, rowsAffected int64) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.results = append(db.results, scriptedResult{fragment: fragment, rowsAffected: rowsAffected})
+}
+
+// Answers statements containing fragment with an error
+func (db *scriptedDB) OnError(fragment string, err error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.results = append(db.results, scriptedResult{fragment: fragment, err: err})
+}
+
+// Statements run so far containing fragment
+func (db *scriptedDB) Statements(fragment string) []scriptedStatement {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	var statements []scriptedStatement
+	for _, statement := range db.statements {
+		if strings.Contains(statement.query, fragment) {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+// Store over the scripted database, with the timeouts and instrumentation of the real one
+func (db *scriptedDB) Store() Store {
+	return newSQLStore(sql.OpenDB(db), 5*time.Second)
+}
+
+func (db *scriptedDB) run(query string, args []driver.NamedValue) scriptedResult {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	db.statements = append(db.statements, scriptedStatement{query, values})
+	for _, result := range db.results {
+		if strings.Contains(query, result.fragment) {
+			return result
+		}
+	}
+	return scriptedResult{rowsAffected: 1}
+}
+
+// driver.Connector
+func (db *scriptedDB) Connect(ctx context.Context) (driver.Conn, error) {
+	return scriptedConn{db}, nil
+}
+
+func (db *scriptedDB) Driver() driver.Driver {
+	return scriptedDriver{db}
+}
+
+type scriptedDriver struct {
+	db *scriptedDB
+}
+
+func (d scriptedDriver) Open(name string) (driver.Conn, error) {
+	return scriptedConn{d.db}, nil
+}
+
+type scriptedConn struct {
+	db *scriptedDB
+}
+
+func (c scriptedConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("scripted database does not prepare statements")
+}
+
+func (c scriptedConn) Close() error {
+	return nil
+}
+
+func (c scriptedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c scriptedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.db.run("BEGIN", nil)
+	return scriptedTx{c.db}, nil
+}
+
+// Passes arguments as they are, so that tests see what the api sent
+func (c scriptedConn) CheckNamedValue(value *driver.NamedValue) error {
+	return nil
+}
+
+func (c scriptedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result := c.db.run(query, args)
+	if result.err != nil {
+		return nil, result.err
+	}
+	return &scriptedRows{columns: result.columns, rows: result.rows}, nil
+}
+
+func (c scriptedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result := c.db.run(query, args)
+	if result.err != nil {
+		return nil, result.err
+	}
+	return driver.RowsAffected(result.rowsAffected), nil
+}
+
+type scriptedTx struct {
+	db *scriptedDB
+}
+
+func (tx scriptedTx) Commit() error {
+	tx.db.run("COMMIT", nil)
+	return nil
+}
+
+func (tx scriptedTx) Rollback() error {
+	tx.db.run("ROLLBACK", nil)
+	return nil
+}
+
+type scriptedRows struct {
+	columns []string
+	rows    [][]driver.Value
+	next    int
+}
+
+func (r *scriptedRows) Columns() []string {
+	return r.columns
+}
+
+func (r *scriptedRows) Close() error {
+	return nil
+}
+
+func (r *scriptedRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// Clock moved forward by tests
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Configuration loaded the way the api loads it, from test values of the required variables
+func testConfig(t *testing.T) *Config {
+	t.Helper()
+	for key, value := range map[string]string{
+		"SIGN_IN_URL":          "http://localhost:8080/signIn",
+		"STATE_SALT":           "state salt",
+		"GOOGLE_ID_SALT":       "google id salt",
+		"GOOGLE_CLIENT_ID":     "client id",
+		"GOOGLE_CLIENT_SECRET": "client secret",
+		"PSQL_INFO":            "postgres://localhost/test",
+		"AWS_S3_BUCKET":        "bucket",
+		"CAPTCHA_SECRET":       "captcha secret",
+		"MODERATOR_EMAILS":     testModerator.Email,
+	} {
+		t.Setenv(key, value)
+	}
+	config, err := loadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// Captcha verifier injected by tests, never configurable
+// Accepts every token except "fail"
+type fakeCaptchaVerifier struct{}
+
+func (fakeCaptchaVerifier) Verify(ctx context.Context, token string, remoteIp string, action string) (*CaptchaResult, error) {
+	if token == "fail" {
+		return &CaptchaResult{Success: false, ErrorCodes: []string{"invalid-input-response"}}, fmt.Errorf("%w: fake failure", errCaptchaRejected)
+	}
+	score := 1.0
+	return &CaptchaResult{Success: true, Score: &score, Action: action, Hostname: "localhost"}, nil
+}
+
+// Blob store keeping blobs in memory
+type fakeBlobStore struct {
+	mutex sync.Mutex
+	blobs map[string][]byte
+}
+
+func newFakeBlobStore() *fakeBlobStore {
+	return &fakeBlobStore{blobs: map[string][]byte{}}
+}
+
+func (b *fakeBlobStore) Put(ctx context.Context, key string, body io.Reader) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.blobs[key] = data
+	return nil
+}
+
+func (b *fakeBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	data, ok := b.blobs[key]
+	if !ok {
+		return nil, fmt.Errorf("no blob %s", key)
+	}
+	return data, nil
+}
+
+func (b *fakeBlobStore) Delete(ctx context.Context, key string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.blobs, key)
+	return nil
+}
+
+func (b *fakeBlobStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Identity provider knowing a fixed set of access tokens
+type fakeIdentityProvider struct {
+	users map[string]*userInfo // by access token
+}
+
+func (p fakeIdentityProvider) AuthCodeURL(state string) string {
+	return "https://accounts.example/auth?state=" + state
+}
+
+func (p fakeIdentityProvider) Exchange(ctx context.Context, code string) (string, error) {
+	if _, ok := p.users[code]; !ok {
+		return "", errInvalidCredentials
+	}
+	return code, nil
+}
+
+func (p fakeIdentityProvider) UserInfo(ctx context.Context, accessToken string) (*userInfo, error) {
+	if accessToken == testUnavailableToken {
+		return nil, errors.New("userinfo unavailable")
+	}
+	user, ok := p.users[accessToken]
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+	return user, nil
+}
+
+// A server wired to fakes, along with the fakes tests script and inspect
+type testServer struct {
+	*Server
+	db    *scriptedDB
+	blobs *fakeBlobStore
+	clock *fakeClock
+	http  *httptest.Server
+}
+
+const (
+	testAccessToken    = "test-access-token"
+	testModeratorToken = "test-moderator-token"
+	// Access token the fake identity provider fails to check, like during an outage
+	testUnavailableToken = "test-unavailable-token"
+)
+
+var (
+	testUser      = &userInfo{Id: "1234567890", Name: "Test User", Email: "test@example.com", VerifiedEmail: true}
+	testModerator = &userInfo{Id: "9876543210", Name: "Test Moderator", Email: "moderator@example.com", VerifiedEmail: true}
+)
+
+// Header signing a request in as testModerator, for s.do
+var asModerator = []string{"Authorization", "Bearer " + testModeratorToken}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	db := &scriptedDB{}
+	blobs := newFakeBlobStore()
+	clock := newFakeClock()
+	identity := fakeIdentityProvider{map[string]*userInfo{testAccessToken: testUser, testModeratorToken: testModerator}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewServer(testConfig(t), db.Store(), blobs, identity, fakeCaptchaVerifier{}, clock, logger)
+	server := &testServer{s, db, blobs, clock, httptest.NewServer(s.Handler())}
+	t.Cleanup(func() {
+		server.http.Close()
+		s.Close()
+	})
+	return server
+}
+
+// Sends a request to the server over http, signed in as testUser if signedIn
+func (s *testServer) do(t *testing.T, method string, path string, contentType string, body string, signedIn bool, headers ...string) *http.Response {
+	t.Helper()
+	request, err := http.NewRequest(method, s.http.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	if signedIn {
+		request.Header.Set("Authorization", "Bearer "+testAccessToken)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+	return response
+}
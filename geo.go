@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultRadius = 25.0
+	maxRadius     = 20000.0
+	mapGridSize   = 16
+	maxMapPoints  = 500
+)
+
+// Great circle distance in km between an article and the point ($lat, $lng)
+// Rounding can push the haversine of antipodal points just above 1, out of the domain of ASIN
+func distanceSql(lat string, lng string) string {
+	return `(6371 * 2 * ASIN(LEAST(1, SQRT(POWER(SIN(RADIANS(latitude - ` + lat + `) / 2), 2) + COS(RADIANS(` + lat + `)) * COS(RADIANS(latitude)) * POWER(SIN(RADIANS(longitude - ` + lng + `) / 2), 2)))))`
+}
+
+type location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	PlaceName string  `json:"placeName"`
+}
+
+func isValidCoordinate(lat float64, lng float64) bool {
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+// Parses a list of comma separated floats (ex. near=lat,lng)
+func parseFloats(query string, count int) ([]float64, bool) {
+	parts := strings.Split(query, ",")
+	if len(parts) != count {
+		return nil, false
+	}
+	floats := make([]float64, count)
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, false
+		}
+		floats[i] = f
+	}
+	return floats, true
+}
+
+// Parses near=lat,lng and radius=km into a distance filter
+func parseNear(nearQuery string, radiusQuery string) (lat float64, lng float64, radius float64, ok bool) {
+	point, ok := parseFloats(nearQuery, 2)
+	if !ok || !isValidCoordinate(point[0], point[1]) {
+		return 0, 0, 0, false
+	}
+	radius = defaultRadius
+	if radiusQuery != "" {
+		var err error
+		radius, err = strconv.ParseFloat(strings.TrimSpace(radiusQuery), 64)
+		// NaN passes every comparison, so it is ruled out first
+		if err != nil || math.IsNaN(radius) || radius <= 0 || radius > maxRadius {
+			return 0, 0, 0, false
+		}
+	}
+	return point[0], point[1], radius, true
+}
+
+func scanLocation(lat sql.NullFloat64, lng sql.NullFloat64, placeName sql.NullString) *location {
+	if !lat.Valid || !lng.Valid {
+		return nil
+	}
+	return &location{lat.Float64, lng.Float64, placeName.String}
+}
+
+// Responds with clustered article points inside a bounding box
+// bbox=minLng,minLat,maxLng,maxLat
+func (s *Server) mapHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	bbox, ok := parseFloats(c.Query("bbox"), 4)
+	if !ok || !isValidCoordinate(bbox[1], bbox[0]) || !isValidCoordinate(bbox[3], bbox[2]) || bbox[0] >= bbox[2] || bbox[1] >= bbox[3] {
+		panic(invalidLocation)
+	}
+	period := determinePeriod(c.Query("period"), s.clock.Now())
+
+	// Cluster points into a grid over the bounding box
+	cellWidth := (bbox[2] - bbox[0]) / mapGridSize
+	cellHeight := (bbox[3] - bbox[1]) / mapGridSize
+	q := `SELECT COUNT(*), AVG(latitude), AVG(longitude), MIN(id) FROM articles
+	WHERE longitude BETWEEN $1 AND $3
+	AND latitude BETWEEN $2 AND $4
+	AND created >= $5
+	AND ` + visibleArticleSql + `
+	GROUP BY FLOOR(longitude / $6), FLOOR(latitude / $7)
+	LIMIT $8`
+	rows, err := s.store.QueryContext(ctx, q, bbox[0], bbox[1], bbox[2], bbox[3], period, cellWidth, cellHeight, maxMapPoints)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	points := []mapPoint{}
+	for rows.Next() {
+		var count int
+		var lat float64
+		var lng float64
+		var id int
+		err = rows.Scan(&count, &lat, &lng, &id)
+		if err != nil {
+			panic(err)
+		}
+		point := mapPoint{Latitude: lat, Longitude: lng, Count: count}
+		if count == 1 {
+			point.ArticleId = &id
+		}
+		points = append(points, point)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	c.JSON(200, mapResponse{len(points), points})
+}
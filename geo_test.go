@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestParseNear(t *testing.T) {
+	tests := []struct {
+		near   string
+		radius string
+		ok     bool
+	}{
+		{"45.5,-122.6", "", true},
+		{"45.5, -122.6", "10", true},
+		{"91,0", "", false},
+		{"NaN,0", "", false},
+		{"0,Inf", "", false},
+		{"45.5", "", false},
+		{"45.5,-122.6", "NaN", false},
+		{"45.5,-122.6", "Inf", false},
+		{"45.5,-122.6", "-Inf", false},
+		{"45.5,-122.6", "0", false},
+		{"45.5,-122.6", "20001", false},
+	}
+	for _, test := range tests {
+		_, _, radius, ok := parseNear(test.near, test.radius)
+		if ok != test.ok {
+			t.Errorf("near=%s&radius=%s: ok %v, want %v", test.near, test.radius, ok, test.ok)
+		}
+		if ok && (radius <= 0 || radius > maxRadius) {
+			t.Errorf("near=%s&radius=%s: radius %v", test.near, test.radius, radius)
+		}
+	}
+}
@@ -1,141 +1,229 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
-	"net/http"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"golang.org/x/oauth2"
 
 	_ "github.com/lib/pq"
 )
 
-func handleRouting() {
+func (s *Server) handleRouting() *gin.Engine {
 	// Create gin handlers
-	router := gin.Default()
+	router := gin.New()
+	router.ForwardedByClientIP = false // c.ClientIP() is the peer, s.clientIp(c) knows which proxies to believe
+	router.Use(tracingMiddleware, s.requestIdMiddleware, accessLogMiddleware, gin.Recovery())
 
 	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{"http://localhost:8080", "https://www.crowdreport.me", "https://www.google.com"}
+	config.AllowOrigins = s.config.CorsOrigins
 	config.AllowMethods = []string{"GET", "POST", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", requestIdHeader, "traceparent", "tracestate", "If-None-Match"}
+	config.ExposeHeaders = []string{requestIdHeader, "ETag"}
+	router.Use(metricsMiddleware)
 	router.Use(cors.New(config))
+	router.Use(noStoreMiddleware)
+
+	// Declare routes under /v1, and the original ones at their original paths as deprecated aliases
+	v1 := router.Group(apiPrefix)
+	s.declareRoutes(v1)
+	v1.GET("/openapi.json", s.openAPIHandler)
+	s.declareLegacyRoutes(router.Group("/", deprecatedMiddleware))
+
+	router.GET("/healthz", s.healthzHandler)
+	router.GET("/readyz", s.readyzHandler)
+	router.GET("/version", s.versionHandler)
+	router.GET("/metrics", s.metricsHandler())
+
+	// Runtime and database pool statistics
+	router.GET("/debug/vars", s.accessTokenMiddleware, s.moderatorMiddleware, gin.WrapH(expvar.Handler()))
+	return router
+}
+
+// Declares the routes of the api (documented in apiOperations)
+func (s *Server) declareRoutes(r gin.IRoutes) {
+	r.GET("/loginUrl", s.loginUrlHandler)
+	r.GET("/accessToken", s.accessTokenHandler)
+	r.GET("/userData", s.accessTokenMiddleware, s.userDataHandler)
+	r.GET("/userArticles", s.accessTokenMiddleware, s.userArticlesHandler)
+	r.GET("/recommended", s.accessTokenMiddleware, s.recommendedHandler)
+
+	r.POST("/create", s.accessTokenMiddleware, s.banMiddleware, s.rateLimitMiddleware("create"), s.createHandler)
+	r.GET("/articles/:id", s.optionalAccessTokenMiddleware, s.fetchArticleHandler)
+	r.DELETE("/articles/:id", s.accessTokenMiddleware, s.banMiddleware, s.deleteArticleHandler)
+	r.POST("/articles/:id/restore", s.accessTokenMiddleware, s.banMiddleware, s.restoreArticleHandler)
+	r.GET("/articles/:id/related", s.optionalAccessTokenMiddleware, s.relatedArticlesHandler)
+	r.GET("/tags", s.tagsHandler)
+
+	r.GET("/articles/:id/hearted", s.accessTokenMiddleware, s.fetchHeartedHandler)
+	r.POST("/heart", s.accessTokenMiddleware, s.banMiddleware, s.rateLimitMiddleware("heart"), s.heartHandler)
+
+	r.POST("/articles/:id/bookmark", s.accessTokenMiddleware, s.bookmarkHandler)
+	r.DELETE("/articles/:id/bookmark", s.accessTokenMiddleware, s.unbookmarkHandler)
+	r.GET("/bookmarks", s.accessTokenMiddleware, s.bookmarksHandler)
+	r.GET("/bookmarks/folders", s.accessTokenMiddleware, s.bookmarkFoldersHandler)
+
+	r.POST("/uploadImage", s.accessTokenMiddleware, s.banMiddleware, s.rateLimitMiddleware("uploadImage"), s.uploadImageHandler)
+	r.GET("/images/:imageName", s.fetchImageHandler)
+
+	r.POST("/articles/:id/reports", s.accessTokenMiddleware, s.banMiddleware, s.reportArticleHandler)
+	r.GET("/userWarnings", s.accessTokenMiddleware, s.userWarningsHandler)
+	r.GET("/moderation/reports", s.accessTokenMiddleware, s.moderatorMiddleware, s.reportsHandler)
+	r.POST("/moderation/reports/:id/resolve", s.accessTokenMiddleware, s.moderatorMiddleware, s.resolveReportHandler)
+	r.GET("/moderation/log", s.accessTokenMiddleware, s.moderatorMiddleware, s.moderationLogHandler)
+
+	r.GET("/moderation/bannedWords", s.accessTokenMiddleware, s.moderatorMiddleware, s.bannedWordsHandler)
+	r.POST("/moderation/bannedWords", s.accessTokenMiddleware, s.moderatorMiddleware, s.addBannedWordHandler)
+	r.DELETE("/moderation/bannedWords/:word", s.accessTokenMiddleware, s.moderatorMiddleware, s.removeBannedWordHandler)
+
+	r.GET("/userBan", s.accessTokenMiddleware, s.userBanHandler)
+	r.POST("/userBan/appeal", s.accessTokenMiddleware, s.appealBanHandler)
+	r.GET("/moderation/bans", s.accessTokenMiddleware, s.moderatorMiddleware, s.bansHandler)
+	r.POST("/moderation/bans", s.accessTokenMiddleware, s.moderatorMiddleware, s.banHandler)
+	r.DELETE("/moderation/bans/:userId", s.accessTokenMiddleware, s.moderatorMiddleware, s.unbanHandler)
+
+	r.GET("/search", s.rateLimitMiddleware("search"), s.searchHandler)
+	r.GET("/map", s.mapHandler)
+}
 
-	// Declare routes
-	router.GET("/loginUrl", loginUrlHandler)
-	router.GET("/accessToken", accessTokenHandler)
-	router.GET("/userData", accessTokenMiddleware, userDataHandler)
-	router.GET("/userArticles", accessTokenMiddleware, userArticlesHandler)
+// Declares the routes the api served before /v1 at their unversioned paths, with the responses they always had
+// Routes added since are only served under /v1
+func (s *Server) declareLegacyRoutes(r gin.IRoutes) {
+	r.GET("/loginUrl", s.loginUrlHandler)
+	r.GET("/accessToken", s.accessTokenHandler)
+	r.GET("/userData", s.accessTokenMiddleware, s.userDataHandler)
+	r.GET("/userArticles", s.accessTokenMiddleware, s.userArticlesHandler)
 
-	router.POST("/create", accessTokenMiddleware, createHandler)
-	router.GET("/articles/:id", fetchArticleHandler)
-	router.DELETE("/articles/:id", accessTokenMiddleware, deleteArticleHandler)
-	router.GET("/tags", tagsHandler)
+	r.POST("/create", s.accessTokenMiddleware, s.banMiddleware, s.rateLimitMiddleware("create"), s.legacyCreateHandler)
+	r.GET("/articles/:id", s.optionalAccessTokenMiddleware, s.fetchArticleHandler)
+	r.DELETE("/articles/:id", s.accessTokenMiddleware, s.banMiddleware, s.legacyDeleteArticleHandler)
+	r.GET("/tags", s.tagsHandler)
 
-	router.GET("/articles/:id/hearted", accessTokenMiddleware, fetchHeartedHandler)
-	router.POST("/heart", accessTokenMiddleware, heartHandler)
+	r.GET("/articles/:id/hearted", s.accessTokenMiddleware, s.fetchHeartedHandler)
+	r.POST("/heart", s.accessTokenMiddleware, s.banMiddleware, s.rateLimitMiddleware("heart"), s.heartHandler)
 
-	router.POST("/uploadImage", accessTokenMiddleware, uploadImageHandler)
-	router.GET("/images/:imageName", fetchImageHandler)
+	r.POST("/uploadImage", s.accessTokenMiddleware, s.banMiddleware, s.rateLimitMiddleware("uploadImage"), s.uploadImageHandler)
+	r.GET("/images/:imageName", s.fetchImageHandler)
 
-	router.GET("/search", searchHandler)
-	router.Run(":" + port)
+	r.GET("/search", s.rateLimitMiddleware("search"), s.searchHandler)
 }
 
 // Responds with login url as string
-func loginUrlHandler(c *gin.Context) {
+func (s *Server) loginUrlHandler(c *gin.Context) {
 	defer handleError(c)
-	url := googleOauthConfig.AuthCodeURL(toSHA1(c.ClientIP() + stateSalt)) // Returns login url to login to google
-	c.JSON(200, gin.H{
-		"loginUrl": url,
-	})
+	url := s.identity.AuthCodeURL(toSHA1(s.clientIp(c) + s.config.StateSalt)) // Returns login url to login to google
+	c.JSON(200, loginUrlResponse{url})
 }
 
 // Responds with access as string
-func accessTokenHandler(c *gin.Context) {
+func (s *Server) accessTokenHandler(c *gin.Context) {
 	defer handleError(c)
 	// Check state
 	queryState := c.Query("state")
-	if toSHA1(c.ClientIP()+stateSalt) != queryState {
+	if toSHA1(s.clientIp(c)+s.config.StateSalt) != queryState {
 		panic(invalidState)
 	}
 	// Get token
-	token, err := googleOauthConfig.Exchange(oauth2.NoContext, c.Query("code"))
+	accessToken, err := s.identity.Exchange(c.Request.Context(), c.Query("code"))
 	if err != nil {
 		panic(invalidCode)
 	}
-	c.JSON(200, gin.H{
-		"accessToken": token.AccessToken,
-	})
+	c.JSON(200, accessTokenResponse{accessToken})
 }
 
-// Middleware to process access token
-func accessTokenMiddleware(c *gin.Context) {
-	defer handleError(c)
+// Returned by tokenUser for users google has not verified the email of
+var errUnverifiedEmail = errors.New("unverified email")
 
+// Google user the bearer token of the Authorization header belongs to
+func (s *Server) tokenUser(c *gin.Context) (*userInfo, error) {
 	// Get access token from Authorization header
 	authHeader := strings.Split(c.GetHeader("Authorization"), " ")
 	if len(authHeader) != 2 || authHeader[0] != "Bearer" {
-		panic(invalidToken)
+		return nil, errInvalidCredentials
 	}
 	accessToken := authHeader[1]
 
 	// Send token to google and get data back
-	response, err := http.Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + accessToken)
+	user, err := s.identity.UserInfo(c.Request.Context(), accessToken)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	defer response.Body.Close()
-	var data map[string]interface{}
-	err = json.NewDecoder(response.Body).Decode(&data)
-	if err != nil || data["error"] != nil {
-		panic(invalidToken)
+	if !user.VerifiedEmail {
+		return nil, errUnverifiedEmail
 	}
-	if !data["verified_email"].(bool) {
-		panic(unverifiedEmail)
+	return user, nil
+}
+
+func setUser(c *gin.Context, user *userInfo) {
+	c.Set("id", user.Id)
+	c.Set("name", user.Name)
+	c.Set("email", user.Email)
+	c.Set("picture", user.Picture)
+}
+
+// Middleware to process access token
+func (s *Server) accessTokenMiddleware(c *gin.Context) {
+	defer handleError(c)
+
+	user, err := s.tokenUser(c)
+	if err != nil {
+		if errors.Is(err, errInvalidCredentials) {
+			panic(invalidToken)
+		}
+		if errors.Is(err, errUnverifiedEmail) {
+			panic(unverifiedEmail)
+		}
+		panic(err)
 	}
+	setUser(c, user)
 
-	c.Set("id", data["id"])
-	c.Set("name", data["name"])
-	c.Set("email", data["email"])
-	c.Set("picture", data["picture"])
+	c.Next()
+}
 
+// Middleware processing the access token only if one is sent
+// Public routes stay public, a token that is bad, expired or can not be checked leaves the request anonymous
+func (s *Server) optionalAccessTokenMiddleware(c *gin.Context) {
+	if c.GetHeader("Authorization") != "" {
+		user, err := s.tokenUser(c)
+		if err != nil {
+			if !errors.Is(err, errInvalidCredentials) && !errors.Is(err, errUnverifiedEmail) {
+				requestLogger(c).Warn("failed to check access token, serving anonymously", "error", err)
+			}
+		} else {
+			setUser(c, user)
+		}
+	}
 	c.Next()
 }
 
 // Responds with google user data
-func userDataHandler(c *gin.Context) {
+func (s *Server) userDataHandler(c *gin.Context) {
 	defer handleError(c)
-	id, _ := c.Get("id")
-	name, _ := c.Get("name")
-	email, _ := c.Get("email")
-	picture, _ := c.Get("picture")
-	c.JSON(200, gin.H{
-		"id":      toSHA1(id.(string) + googleIdSalt),
-		"name":    name,
-		"email":   email,
-		"picture": picture,
+	c.JSON(200, userDataResponse{
+		Id:      toSHA1(c.GetString("id") + s.config.GoogleIdSalt),
+		Name:    c.GetString("name"),
+		Email:   c.GetString("email"),
+		Picture: c.GetString("picture"),
 	})
 }
 
 // Responds with articles created by user
-func userArticlesHandler(c *gin.Context) {
+func (s *Server) userArticlesHandler(c *gin.Context) {
 	defer handleError(c)
+	ctx := c.Request.Context()
 
 	authorGoogleId, _ := c.Get("id")
 
 	// Check validity of limit and offset
 	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	if err != nil || limit < 1 || limit > 10 {
+	if err != nil || limit < 1 || limit > s.config.UserArticlesMaxLimit {
 		panic(invalidNumber)
 	}
 	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
@@ -143,16 +231,17 @@ func userArticlesHandler(c *gin.Context) {
 		panic(invalidNumber)
 	}
 
-	period := determinePeriod(c.Query("period"))
+	period := determinePeriod(c.Query("period"), s.clock.Now())
 	sort := determineSort(c.Query("sort"))
 
 	// Perform sql query
-	var rows *sql.Rows
+	var rows *Rows
 	q := `SELECT id, author, image_url, title, tags, views, hearts, created FROM articles
 	WHERE created >= $1
 	AND author_google_id=$2
+	AND deleted_at IS NULL
 	ORDER BY ` + sort + ` LIMIT $3 OFFSET $4`
-	rows, err = db.Query(q, period, authorGoogleId, limit, offset)
+	rows, err = s.store.QueryContext(ctx, q, period, authorGoogleId, limit, offset)
 
 	if err != nil {
 		panic(err)
@@ -160,7 +249,7 @@ func userArticlesHandler(c *gin.Context) {
 	defer rows.Close()
 
 	// Create json response
-	var articles []gin.H
+	articles := []articleSummary{}
 	for rows.Next() {
 		var id int
 		var author string
@@ -174,16 +263,7 @@ func userArticlesHandler(c *gin.Context) {
 		if err != nil {
 			panic(err)
 		}
-		articles = append(articles, gin.H{
-			"id":       id,
-			"author":   author,
-			"imageUrl": imageUrl,
-			"title":    title,
-			"tags":     strings.Split(tags, ","),
-			"views":    views,
-			"hearts":   hearts,
-			"created":  created,
-		})
+		articles = append(articles, articleSummary{id, author, imageUrl, title, strings.Split(tags, ","), views, hearts, created})
 	}
 
 	err = rows.Err()
@@ -195,113 +275,235 @@ func userArticlesHandler(c *gin.Context) {
 		}
 	}
 
-	c.JSON(200, gin.H{
-		"count":    len(articles),
-		"articles": articles,
-	})
+	c.JSON(200, articleListResponse{len(articles), articles})
 }
 
-// This create handler needs some cleaning up
-func createHandler(c *gin.Context) {
+// Checks the rules of an article that need the config or the database, skipping fields that already failed
+func (s *Server) checkArticleRequest(ctx context.Context, req *createArticleRequest, failed fieldErrors) fieldErrors {
+	skip := map[string]bool{}
+	for _, field := range failed {
+		skip[field.Field] = true
+	}
+	var fields fieldErrors
+	if !skip["imageUrl"] && !s.isStoreImageUrl(req.ImageUrl) {
+		fields = append(fields, fieldError{"imageUrl", "must be an image from our image store"})
+	}
+	for i, item := range req.Media {
+		field := fmt.Sprintf("media[%d].url", i)
+		if !skip[field] && !s.isStoreImageUrl(item.Url) {
+			fields = append(fields, fieldError{field, "must be an image from our image store"})
+		}
+	}
+	if !skip["body"] && !s.validateArticleBody(req.Body) {
+		fields = append(fields, fieldError{"body", "contains a tag, attribute or image that is not allowed"})
+	}
+	if !skip["tags"] {
+		for _, tag := range strings.Split(strings.ToLower(req.Tags), ",") {
+			var exists bool
+			q := `SELECT exists(SELECT 1 FROM tags WHERE tag=$1) AS "exists"`
+			err := s.store.QueryRowContext(ctx, q, tag).Scan(&exists)
+			if err != nil {
+				panic(err)
+			}
+			if !exists {
+				fields = append(fields, fieldError{"tags", fmt.Sprintf("unknown tag %q", tag)})
+			}
+		}
+	}
+	return fields
+}
+
+func (s *Server) createHandler(c *gin.Context) {
 	defer handleError(c)
+	c.JSON(201, s.createArticle(c))
+}
+
+// Creates an article for the deprecated unversioned route, which predates field errors and answers invalidArticle instead
+func (s *Server) legacyCreateHandler(c *gin.Context) {
+	defer handleError(c)
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(fieldErrors); ok {
+				r = invalidArticle
+			}
+			panic(r)
+		}
+	}()
+	c.JSON(201, s.createArticle(c))
+}
+
+// Creates (or replaces) the article of the request body by the signed in user
+func (s *Server) createArticle(c *gin.Context) createArticleResponse {
+	ctx := c.Request.Context()
 
 	author, _ := c.Get("name")
 	authorGoogleId, _ := c.Get("id")
-	imageUrl := c.DefaultPostForm("imageUrl", "")
-	title := c.DefaultPostForm("title", "")
-	body := c.DefaultPostForm("body", "")
-	tags := c.DefaultPostForm("tags", "")
-	captcha := c.DefaultPostForm("captcha", "")
-
-	replaceId, err := strconv.Atoi(c.DefaultPostForm("replaceId", "-1"))
-	if err != nil {
-		panic(invalidNumber)
-	}
 
-	// Validate captcha
-	response, err := http.Get("https://www.google.com/recaptcha/api/siteverify?secret=" + reCaptchaSecret + "&response=" + captcha + "&remoteip=" + c.ClientIP())
-	if response.StatusCode < 200 || response.StatusCode > 299 {
-		panic(invalidCaptcha)
+	var req createArticleRequest
+	fields := bindRequest(c, &req)
+	fields = append(fields, s.checkArticleRequest(ctx, &req, fields)...)
+	if len(fields) > 0 {
+		requestLogger(c).Debug("invalid article", "fields", fields)
+		panic(fields)
 	}
-
-	// Check validity of image url
-	match, _ := regexp.MatchString(imageUrlRgx, imageUrl)
-	if !match {
-		fmt.Println("Inavlid image url.")
-		panic(invalidArticle)
+	imageUrl, title, body, media := req.ImageUrl, req.Title, req.Body, req.Media
+	tags := strings.ToLower(req.Tags)
+	var place *location
+	if req.Latitude != nil {
+		place = &location{*req.Latitude, *req.Longitude, strings.TrimSpace(req.PlaceName)}
 	}
-
-	// Check validity of title
-	match, _ = regexp.MatchString(titleRgx, title)
-	if !match {
-		fmt.Println("Inavlid title.")
-		panic(invalidArticle)
+	replaceId := -1
+	if req.ReplaceId > 0 {
+		replaceId = req.ReplaceId
 	}
 
-	// Check tags string validity
-	match, _ = regexp.MatchString(tagsRgx, tags)
-	if !match {
-		fmt.Println("Inavlid tags string.")
-		panic(invalidArticle)
+	// Validate captcha
+	_, err := s.captcha.Verify(ctx, req.Captcha, s.clientIp(c), "create")
+	if err != nil {
+		if errors.Is(err, errCaptchaRejected) {
+			captchaFailures.WithLabelValues("rejected").Inc()
+			requestLogger(c).Info("captcha rejected", "error", err)
+			panic(invalidCaptcha)
+		}
+		captchaFailures.WithLabelValues("error").Inc()
+		panic(err)
 	}
 
-	// Check body length validity
-	if len(body) < 300 || len(body) > 10000 {
-		fmt.Println("Inavlid body length.")
-		panic(invalidArticle)
+	// Score content for spam
+	score, reasons, err := s.checkContent(ctx, articleDraft{authorGoogleId.(string), replaceId, title, body, tags})
+	if err != nil {
+		panic(err)
 	}
-
-	// Check validity of body
-	if !validateArticleBody(body) {
-		fmt.Println("Inavlid body.")
-		panic(invalidArticle)
+	if score >= s.config.SpamRejectScore {
+		requestLogger(c).Info("rejected spam", "score", score, "reasons", reasons)
+		panic(spamDetected)
 	}
+	held := score >= s.config.SpamHoldScore
 
-	// Check validity of tags (if they exist)
-	tags = strings.ToLower(tags)
-	tagsArray := strings.Split(tags, ",")
-	for _, tag := range tagsArray {
-		var exists bool
-		q := `SELECT exists(SELECT 1 FROM tags WHERE tag=$1) AS "exists"`
-		err := db.QueryRow(q, tag).Scan(&exists)
-		if err != nil {
-			panic(err)
-		}
-		if !exists {
-			panic(invalidArticle)
-		}
+	// Held articles are written hidden, so that they are never visible even if a later step fails
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		panic(err)
 	}
+	defer tx.Rollback()
 
 	// Create new article and scan id
 	// Or update existing article
 	var id int
 	var q string
+	var latitude sql.NullFloat64
+	var longitude sql.NullFloat64
+	var placeName sql.NullString
+	if place != nil {
+		latitude = sql.NullFloat64{Float64: place.Latitude, Valid: true}
+		longitude = sql.NullFloat64{Float64: place.Longitude, Valid: true}
+		placeName = sql.NullString{String: place.PlaceName, Valid: true}
+	}
 	if replaceId > -1 {
-		q = `UPDATE articles SET author = $1, author_google_id = $2, image_url = $3, title = $4, body = $5, tags = $6 WHERE id = $7 RETURNING id`
-		err = db.QueryRow(q, author, authorGoogleId, imageUrl, title, body, tags, replaceId).Scan(&id)
+		// Only the author may replace their article, deleted articles stay deleted
+		q = `UPDATE articles SET author = $1, image_url = $3, title = $4, body = $5, tags = $6, latitude = $7, longitude = $8, place_name = $9, updated = NOW(), hidden = hidden OR $11
+		WHERE id = $10 AND author_google_id = $2 AND deleted_at IS NULL RETURNING id`
+		err = tx.QueryRowContext(ctx, q, author, authorGoogleId, imageUrl, title, body, tags, latitude, longitude, placeName, replaceId, held).Scan(&id)
 	} else {
-		q = `INSERT INTO articles (author, author_google_id, image_url, title, body, tags) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
-		err = db.QueryRow(q, author, authorGoogleId, imageUrl, title, body, tags).Scan(&id)
+		q = `INSERT INTO articles (author, author_google_id, image_url, title, body, tags, latitude, longitude, place_name, hidden) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`
+		err = tx.QueryRowContext(ctx, q, author, authorGoogleId, imageUrl, title, body, tags, latitude, longitude, placeName, held).Scan(&id)
+	}
+	if err == sql.ErrNoRows {
+		// Not telling apart articles of others from missing ones
+		panic(notFound)
+	}
+	if err != nil {
+		panic(err)
 	}
+
+	// Bans find the author by their salted id
+	err = s.rememberUserId(ctx, tx, authorGoogleId.(string))
+	if err != nil {
+		panic(err)
+	}
+
+	// Save media list
+	err = s.saveArticleMedia(ctx, tx, id, media)
+	if err != nil {
+		panic(err)
+	}
+
+	// Score the article now rather than at the next ranking
+	err = s.rankArticles(ctx, tx, id)
 	if err != nil {
 		panic(err)
 	}
 
 	// Calculate tsvector for article
 	q = `UPDATE articles SET vector=to_tsvector($1 || ' ' || $2 || ' ' || $3 || ' ' || $4) WHERE id=$5`
-	_, err = db.Exec(q, title, tags, body, author, id)
+	_, err = tx.ExecContext(ctx, q, title, tags, body, author, id)
 	if err != nil {
 		panic(err)
 	}
 
-	c.JSON(201, gin.H{
-		"id": id,
-	})
+	// Queue article for moderation instead of publishing it
+	if held {
+		err = s.holdArticle(ctx, tx, id, reasons)
+		if err != nil {
+			panic(err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	s.invalidateArticle(ctx, id)
+	articlesCreated.WithLabelValues(strconv.FormatBool(held)).Inc()
+	return createArticleResponse{id, held}
 }
 
-func fetchArticleHandler(c *gin.Context) {
+func (s *Server) fetchArticleHandler(c *gin.Context) {
 	defer handleError(c)
+	ctx := c.Request.Context()
 
+	// Check if id is valid
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 0 {
+		panic(invalidNumber)
+	}
+
+	// Fetch article
+	var article articleResponse
+	err = s.cached(ctx, s.articleCacheKey(ctx, id), articleCacheTTL, &article, func(ctx context.Context) (interface{}, error) {
+		return s.loadArticle(ctx, id)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			panic(notFound)
+		} else {
+			panic(err)
+		}
+	}
+
+	// Increment view column (buffered), revalidated copies count as views too
+	s.views.Add(id)
+
+	// Signed in users also get their bookmark, so only they may cache their copy
+	// There is no Last-Modified, the updated column does not change with the hearts and views
+	c.Header("Vary", "Authorization")
+	if userId := c.GetString("id"); userId != "" {
+		article.Bookmark, err = s.loadBookmark(ctx, userId, id)
+		if err != nil {
+			panic(err)
+		}
+		// The bookmark may change without the article, only the etag tells
+		if notModified(c, userArticlePolicy, userArticleETag(&article)) {
+			return
+		}
+	} else if notModified(c, articlePolicy, articleETag(&article)) {
+		return
+	}
+	c.JSON(200, article)
+}
+
+// Loads a visible article and its media, sql.ErrNoRows if there is none
+func (s *Server) loadArticle(ctx context.Context, articleId int) (*articleResponse, error) {
 	var id int
 	var author string
 	var authorGoogleId string
@@ -312,46 +514,56 @@ func fetchArticleHandler(c *gin.Context) {
 	var views int
 	var hearts int
 	var created time.Time
-
-	// Check if id is valid
-	temp, err := strconv.Atoi(c.Param("id"))
-	if err != nil || temp < 0 {
-		panic(invalidNumber)
+	var updated time.Time
+	var latitude sql.NullFloat64
+	var longitude sql.NullFloat64
+	var placeName sql.NullString
+
+	q := `SELECT id, author, author_google_id, image_url, title, body, tags, views, hearts, created, updated, latitude, longitude, place_name FROM articles WHERE id=$1 AND ` + visibleArticleSql
+	row := s.store.QueryRowContext(ctx, q, articleId)
+	err := row.Scan(&id, &author, &authorGoogleId, &imageUrl, &title, &body, &tags, &views, &hearts, &created, &updated, &latitude, &longitude, &placeName)
+	if err != nil {
+		return nil, err
 	}
 
-	// Fetch article
-	q := `SELECT id, author, author_google_id, image_url, title, body, tags, views, hearts, created FROM articles WHERE id=$1`
-	row := db.QueryRow(q, c.Param("id"))
-	err = row.Scan(&id, &author, &authorGoogleId, &imageUrl, &title, &body, &tags, &views, &hearts, &created)
-
+	// Fetch media
+	media, err := s.fetchArticleMedia(ctx, id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			panic(notFound)
-		} else {
-			panic(err)
-		}
-	}
+		return nil, err
+	}
+
+	return &articleResponse{
+		Id:             id,
+		Author:         author,
+		AuthorGoogleId: toSHA1(authorGoogleId + s.config.GoogleIdSalt),
+		ImageUrl:       imageUrl,
+		Title:          title,
+		Body:           body,
+		Tags:           strings.Split(tags, ","),
+		Media:          media,
+		Location:       scanLocation(latitude, longitude, placeName),
+		Views:          views,
+		Hearts:         hearts,
+		Created:        created,
+		Updated:        updated,
+	}, nil
+}
 
-	// Increment view column
-	q = `UPDATE articles SET views = views + 1 WHERE id=$1`
-	_, err = db.Exec(q, id)
-
-	c.JSON(200, gin.H{
-		"id":             id,
-		"author":         author,
-		"authorGoogleId": toSHA1(authorGoogleId + googleIdSalt),
-		"imageUrl":       imageUrl,
-		"title":          title,
-		"body":           body,
-		"tags":           strings.Split(tags, ","),
-		"views":          views,
-		"hearts":         hearts,
-		"created":        created,
-	})
+func (s *Server) deleteArticleHandler(c *gin.Context) {
+	defer handleError(c)
+	c.JSON(200, articleIdResponse{s.deleteArticle(c)})
 }
 
-func deleteArticleHandler(c *gin.Context) {
+// Deletes an article for the deprecated unversioned route, which responds with the id as a string
+func (s *Server) legacyDeleteArticleHandler(c *gin.Context) {
 	defer handleError(c)
+	s.deleteArticle(c)
+	c.JSON(200, legacyArticleIdResponse{c.Param("id")})
+}
+
+// Soft deletes the article of the id param (by its author or a moderator) and returns its id
+func (s *Server) deleteArticle(c *gin.Context) int {
+	ctx := c.Request.Context()
 
 	authorGoogleId, _ := c.Get("id")
 	email, _ := c.Get("email")
@@ -364,8 +576,8 @@ func deleteArticleHandler(c *gin.Context) {
 	}
 
 	// Check if article exists
-	q := `SELECT author_google_id FROM articles WHERE id=$1`
-	row := db.QueryRow(q, articleId)
+	q := `SELECT author_google_id FROM articles WHERE id=$1 AND deleted_at IS NULL`
+	row := s.store.QueryRowContext(ctx, q, articleId)
 	var id string
 	err = row.Scan(&id)
 
@@ -377,62 +589,80 @@ func deleteArticleHandler(c *gin.Context) {
 		}
 	}
 
-	if email == adminEmail {
-		fmt.Println("admin authorized to delete article")
-	} else if id != authorGoogleId {
-		panic(noPermission)
+	moderated := false
+	if id != authorGoogleId {
+		if !s.isModerator(email) {
+			panic(noPermission)
+		}
+		requestLogger(c).Info("moderator deleting article", "articleId", articleId)
+		moderated = true
 	}
 
-	// Delete hearts
-	q = `DELETE FROM hearts WHERE articleId=$1`
-	_, err = db.Exec(q, articleId)
+	// Soft delete article so it can be restored until it is purged
+	tx, err := s.store.BeginTx(ctx, nil)
 	if err != nil {
 		panic(err)
 	}
-
-	// Delete article
-	q = `DELETE FROM articles WHERE id=$1`
-	_, err = db.Exec(q, articleId)
+	defer tx.Rollback()
+	err = softDeleteArticle(ctx, tx, articleId, email)
+	if err != nil {
+		panic(err)
+	}
+	if moderated {
+		err = logModeration(ctx, tx, email, articleId, nil, "delete", "")
+		if err != nil {
+			panic(err)
+		}
+	}
+	err = tx.Commit()
 	if err != nil {
 		panic(err)
 	}
 
-	c.JSON(200, gin.H{
-		"id": articleId,
-	})
+	s.invalidateArticle(ctx, temp)
+	return temp
 }
 
-func tagsHandler(c *gin.Context) {
+func (s *Server) tagsHandler(c *gin.Context) {
 	defer handleError(c)
+	ctx := c.Request.Context()
 
-	q := `SELECT * FROM tags ORDER BY tag`
-	rows, err := db.Query(q)
+	var tags tagsResponse
+	err := s.cached(ctx, "tags", tagsCacheTTL, &tags, s.loadTags)
 	if err != nil {
 		panic(err)
 	}
+
+	cachedJSON(c, tagsPolicy, tags)
+}
+
+func (s *Server) loadTags(ctx context.Context) (interface{}, error) {
+	q := `SELECT * FROM tags ORDER BY tag`
+	rows, err := s.store.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
-	var tags []string
+	tags := []string{}
 	for rows.Next() {
 		var tag string
 		err = rows.Scan(&tag)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		tags = append(tags, tag)
 	}
-
-	c.JSON(200, gin.H{
-		"tags": tags,
-	})
+	return tagsResponse{tags}, rows.Err()
 }
 
-func searchHandler(c *gin.Context) {
+func (s *Server) searchHandler(c *gin.Context) {
 	defer handleError(c)
+	ctx := c.Request.Context()
 
 	// Check validity of limit and offset
 	limit, err := strconv.Atoi(c.DefaultQuery("limit", "6"))
-	if err != nil || limit < 1 || limit > 16 {
+	if err != nil || limit < 1 || limit > s.config.SearchMaxLimit {
 		panic(invalidNumber)
 	}
 	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
@@ -440,7 +670,7 @@ func searchHandler(c *gin.Context) {
 		panic(invalidNumber)
 	}
 
-	period := determinePeriod(c.Query("period"))
+	period := determinePeriod(c.Query("period"), s.clock.Now())
 	sort := determineSort(c.Query("sort"))
 
 	// Parse search query param q
@@ -454,28 +684,56 @@ func searchHandler(c *gin.Context) {
 		}
 	}
 
-	// Perform sql query
-	var rows *sql.Rows
-	if len(searchWords) == 1 && searchWords[0] == "" { // If q is empty
-		q := `SELECT id, author, image_url, title, tags, views, hearts, created FROM articles
-		WHERE created >= $1
-		ORDER BY ` + sort + ` LIMIT $2 OFFSET $3`
-		rows, err = db.Query(q, period, limit, offset)
-	} else {
-		q := `SELECT id, author, image_url, title, tags, views, hearts, created FROM articles
-		WHERE vector @@ to_tsquery($1)
-		AND created >= $2 
-		ORDER BY ` + sort + ` LIMIT $3 OFFSET $4`
-		rows, err = db.Query(q, search, period, limit, offset)
+	// Build filters
+	var args []interface{}
+	filters := []string{visibleArticleSql, "created >= " + addArg(&args, period)}
+	if searchQuery != "" {
+		filters = append(filters, "vector @@ to_tsquery("+addArg(&args, search)+")")
+	}
+	if c.Query("near") != "" {
+		lat, lng, radius, ok := parseNear(c.Query("near"), c.Query("radius"))
+		if !ok {
+			panic(invalidLocation)
+		}
+		filters = append(filters, "latitude IS NOT NULL", distanceSql(addArg(&args, lat), addArg(&args, lng))+" <= "+addArg(&args, radius))
+	}
+
+	q := `SELECT id, author, image_url, title, tags, views, hearts, created FROM articles
+	WHERE ` + strings.Join(filters, " AND ") + `
+	ORDER BY ` + sort + ` LIMIT ` + addArg(&args, limit) + ` OFFSET ` + addArg(&args, offset)
+	load := func(ctx context.Context) (interface{}, error) {
+		return s.loadArticleList(ctx, q, args...)
 	}
 
+	// First pages of the plain listings (ex. the popular front page) are shared by everyone
+	var articles articleListResponse
+	if searchQuery == "" && c.Query("near") == "" && offset == 0 {
+		key := fmt.Sprintf("listing:%s:%s:%s:%d", s.generation(ctx, listingsGeneration), sort, periodName(c.Query("period")), limit)
+		err = s.cached(ctx, key, listingCacheTTL, &articles, load)
+	} else {
+		var result interface{}
+		result, err = load(ctx)
+		if err == nil {
+			articles = result.(articleListResponse)
+		}
+	}
 	if err != nil {
 		panic(err)
 	}
+
+	searches.Inc()
+	cachedJSON(c, searchPolicy, articles)
+}
+
+// Runs a query selecting article summaries
+func (s *Server) loadArticleList(ctx context.Context, q string, args ...interface{}) (interface{}, error) {
+	rows, err := s.store.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
-	// Create json response
-	var articles []gin.H
+	articles := []articleSummary{}
 	for rows.Next() {
 		var id int
 		var author string
@@ -487,44 +745,21 @@ func searchHandler(c *gin.Context) {
 		var created time.Time
 		err = rows.Scan(&id, &author, &imageUrl, &title, &tags, &views, &hearts, &created)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		articles = append(articles, gin.H{
-			"id":       id,
-			"author":   author,
-			"imageUrl": imageUrl,
-			"title":    title,
-			"tags":     strings.Split(tags, ","),
-			"views":    views,
-			"hearts":   hearts,
-			"created":  created,
-		})
+		articles = append(articles, articleSummary{id, author, imageUrl, title, strings.Split(tags, ","), views, hearts, created})
 	}
-
-	err = rows.Err()
-	if err != nil {
-		if err == sql.ErrNoRows {
-			panic(notFound)
-		} else {
-			panic(err)
-		}
-	}
-
-	c.JSON(200, gin.H{
-		"count":    len(articles),
-		"articles": articles,
-	})
+	return articleListResponse{len(articles), articles}, rows.Err()
 }
 
-func uploadImageHandler(c *gin.Context) {
+func (s *Server) uploadImageHandler(c *gin.Context) {
 	defer handleError(c)
 
 	multipart, err := c.FormFile("image")
 	if err != nil {
 		panic(err)
 	}
-	fmt.Println(multipart.Filename)
-	if multipart.Size > maxImageSize {
+	if multipart.Size > s.config.MaxImageSize {
 		panic(fileTooLarge)
 	}
 	mime := getMime(multipart.Filename)
@@ -537,45 +772,32 @@ func uploadImageHandler(c *gin.Context) {
 		panic(err)
 	}
 
-	now := time.Now()
+	now := s.clock.Now()
 	keyString := fmt.Sprintf("%d%d%d%d%d%d%d.%s", now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), multipart.Size, mime)
-	uploader := s3manager.NewUploader(awsSession)
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(awsBucket),
-		Key:    &keyString,
-		Body:   file,
-	})
-
+	err = s.blobs.Put(c.Request.Context(), keyString, file)
 	if err != nil {
 		panic(err)
 	}
 
-	c.JSON(200, gin.H{
-		"url": imagePath + keyString,
-	})
+	c.JSON(200, imageUrlResponse{s.config.ImagePath + keyString})
 }
 
-func fetchImageHandler(c *gin.Context) {
+func (s *Server) fetchImageHandler(c *gin.Context) {
 	defer handleError(c)
 
 	imageName := c.Param("imageName")
 
-	imageBuffer := aws.NewWriteAtBuffer([]byte{})
-
-	downloader := s3manager.NewDownloader(awsSession)
-	_, err := downloader.Download(imageBuffer, &s3.GetObjectInput{
-		Bucket: aws.String(awsBucket),
-		Key:    &imageName,
-	})
+	image, err := s.blobs.Get(c.Request.Context(), imageName)
 	if err != nil {
 		panic(err)
 	}
 
-	c.Writer.Write(imageBuffer.Bytes())
+	c.Writer.Write(image)
 }
 
-func fetchHeartedHandler(c *gin.Context) {
+func (s *Server) fetchHeartedHandler(c *gin.Context) {
 	defer handleError(c)
+	ctx := c.Request.Context()
 
 	articleId := c.Param("id")
 	userId, _ := c.Get("id")
@@ -583,65 +805,66 @@ func fetchHeartedHandler(c *gin.Context) {
 	// check if heart exists
 	var exists bool
 	q := `SELECT exists(SELECT 1 FROM hearts WHERE articleId=$1 AND userId=$2) AS "exists"`
-	err := db.QueryRow(q, articleId, userId).Scan(&exists)
+	err := s.store.QueryRowContext(ctx, q, articleId, userId).Scan(&exists)
 	if err != nil {
 		panic(err)
 	}
 
-	c.JSON(200, gin.H{
-		"hearted": exists,
-	})
+	c.JSON(200, heartedResponse{exists})
 }
 
-func heartHandler(c *gin.Context) {
+func (s *Server) heartHandler(c *gin.Context) {
 	defer handleError(c)
+	ctx := c.Request.Context()
 
-	articleId := c.DefaultPostForm("articleId", "")
+	var req heartRequest
+	fields := bindRequest(c, &req)
+	if len(fields) > 0 {
+		panic(fields)
+	}
+	articleId := req.ArticleId
 	userId, _ := c.Get("id")
 
-	// check if heart exists
-	var exists bool
-	q := `SELECT exists(SELECT 1 FROM hearts WHERE articleId=$1 AND userId=$2) AS "exists"`
-	err := db.QueryRow(q, articleId, userId).Scan(&exists)
+	// Toggle the heart, concurrent toggles of the same heart only count the rows they changed
+	tx, err := s.store.BeginTx(ctx, nil)
 	if err != nil {
 		panic(err)
 	}
+	defer tx.Rollback()
 
 	hearted := false
-	if exists {
-		// Delete heart
-		q := `DELETE FROM hearts WHERE articleId=$1 AND userId=$2`
-		_, err = db.Exec(q, articleId, userId)
-		if err != nil {
-			panic(err)
-		}
-
-		q = `UPDATE articles SET hearts = hearts - 1 WHERE id=$1`
-		_, err = db.Exec(q, articleId)
-		if err != nil {
-			panic(err)
-		}
-
-	} else {
-		// Add heart
-		q := `INSERT INTO hearts(articleId, userId) VALUES ($1, $2)`
-		_, err = db.Exec(q, articleId, userId)
+	result, err := tx.ExecContext(ctx, `DELETE FROM hearts WHERE articleId=$1 AND userId=$2`, articleId, userId)
+	if err != nil {
+		panic(err)
+	}
+	change, _ := result.RowsAffected()
+	change = -change
+	if change == 0 {
+		q := `INSERT INTO hearts(articleId, userId) VALUES ($1, $2) ON CONFLICT (articleId, userId) DO NOTHING`
+		result, err = tx.ExecContext(ctx, q, articleId, userId)
 		if err != nil {
 			panic(err)
 		}
-
-		q = `UPDATE articles SET hearts = hearts + 1 WHERE id=$1`
-		_, err = db.Exec(q, articleId)
+		change, _ = result.RowsAffected()
+		hearted = true
+	}
+	if change != 0 {
+		_, err = tx.ExecContext(ctx, `UPDATE articles SET hearts = hearts + $2 WHERE id=$1`, articleId, change)
 		if err != nil {
 			panic(err)
 		}
-		hearted = true
 	}
+	err = tx.Commit()
 	if err != nil {
 		panic(err)
 	}
 
-	c.JSON(200, gin.H{
-		"hearted": hearted,
-	})
+	s.invalidateArticleCounts(ctx, articleId)
+	if hearted {
+		heartsToggled.WithLabelValues("heart").Inc()
+	} else {
+		heartsToggled.WithLabelValues("unheart").Inc()
+	}
+
+	c.JSON(200, heartedResponse{hearted})
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const readinessTimeout = 3 * time.Second
+
+// Set at build time (ex. go build -ldflags "-X main.buildCommit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)")
+var (
+	buildCommit = "unknown"
+	buildTime   = "unknown"
+)
+
+// A dependency the api needs to serve requests
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+func (s *Server) readinessChecks() []readinessCheck {
+	return []readinessCheck{
+		{"database", s.store.PingContext},
+		{"blobStore", s.blobs.Ping},
+		{"migrations", func(ctx context.Context) error {
+			pending, err := pendingMigrations(ctx, s.store)
+			if err != nil {
+				return err
+			}
+			if pending > 0 {
+				return fmt.Errorf("%d pending migration(s)", pending)
+			}
+			return nil
+		}},
+	}
+}
+
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
+// Only the status is exposed, the error of a failing check is logged
+type checkResult struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+type readinessResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+type versionResponse struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Responds as long as the process is alive
+func (s *Server) healthzHandler(c *gin.Context) {
+	c.JSON(200, statusResponse{"ok"})
+}
+
+// Responds with the status and latency of every dependency, 503 if any of them is not ready
+func (s *Server) readyzHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := s.readinessChecks()
+	response := readinessResponse{Status: "ok", Checks: map[string]checkResult{}}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check readinessCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := check.check(ctx)
+			result := checkResult{Status: "ok", LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = "unavailable"
+				requestLogger(c).Warn("readiness check failed", "check", check.name, "error", err)
+			}
+			mutex.Lock()
+			response.Checks[check.name] = result
+			if err != nil {
+				response.Status = "unavailable"
+			}
+			mutex.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	if response.Status != "ok" {
+		c.JSON(503, response)
+		return
+	}
+	c.JSON(200, response)
+}
+
+// Responds with the build the api is running
+func (s *Server) versionHandler(c *gin.Context) {
+	c.JSON(200, versionResponse{buildCommit, buildTime, runtime.Version()})
+}
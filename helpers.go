@@ -2,8 +2,8 @@ package main
 
 import (
 	"crypto/sha1"
+	"database/sql"
 	"encoding/hex"
-	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
@@ -11,12 +11,14 @@ import (
 )
 
 const (
-	alphaNum     = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ12345678901234567890"
-	maxImageSize = 500000
-	imageUrlRgx  = `^https://api.crowdreport.me/images/.+$`
-	titleRgx     = `^\S.{13,73}\S$`
-	tagsRgx      = "^.{1,75}$"
-	tagRgx       = `^(\/?)(h[1-6]|p|br|u|strong|em|ul|ol|li|span|img|iframe)(.?((class|src|frameborder|allowfullscreen)="[^";]*"|style="((background-color|color): ?[^":;]*; ?){0,5}")){0,5}$`
+	alphaNum = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ12345678901234567890"
+	titleRgx = `^\S.{13,73}\S$`
+	tagsRgx  = "^.{1,75}$"
+	tagRgx   = `^(\/?)(h[1-6]|p|br|u|strong|em|ul|ol|li|span|img|iframe)(.?((class|src|frameborder|allowfullscreen)="[^";]*"|style="((background-color|color): ?[^":;]*; ?){0,5}")){0,5}$`
+
+	// Filter for articles that are not hidden by moderation, deleted or hidden along with their banned author
+	visibleArticleSql = `NOT hidden AND deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM bans
+	WHERE bans.google_id = articles.author_google_id AND bans.hide_articles AND (bans.expires IS NULL OR bans.expires > NOW()))`
 )
 
 var allowedImageMimes = [8]string{"png", "jpg", "jpeg", "gif", "bmp", "jfif", "svg", "webp"}
@@ -46,7 +48,7 @@ func toSHA1(str string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-func validateArticleBody(body string) bool {
+func (s *Server) validateArticleBody(body string) bool {
 	var tags []string
 	currentTag := ""
 	open := false
@@ -55,10 +57,12 @@ func validateArticleBody(body string) bool {
 			open = true
 		} else if body[i] == '>' {
 			match, _ := regexp.MatchString(tagRgx, currentTag)
-			fmt.Println(currentTag + " : " + strconv.FormatBool(match))
 			if !match {
 				return false
 			}
+			if strings.HasPrefix(currentTag, "img") && !s.isStoreImageTag(currentTag) {
+				return false
+			}
 			tags = append(tags, currentTag)
 			open = false
 			currentTag = ""
@@ -69,8 +73,8 @@ func validateArticleBody(body string) bool {
 	return true
 }
 
-func determinePeriod(periodQuery string) time.Time {
-	period := time.Now()
+func determinePeriod(periodQuery string, now time.Time) time.Time {
+	period := now
 	if periodQuery == "day" {
 		period = period.Add(time.Duration(-24) * time.Hour)
 	} else if periodQuery == "week" {
@@ -85,6 +89,15 @@ func determinePeriod(periodQuery string) time.Time {
 	return period
 }
 
+// Canonical name of a period query, for cache keys
+func periodName(periodQuery string) string {
+	switch periodQuery {
+	case "day", "week", "month", "year":
+		return periodQuery
+	}
+	return "all"
+}
+
 func determineSort(sortQuery string) string {
 	if sortQuery == "new" {
 		return "created DESC"
@@ -92,6 +105,24 @@ func determineSort(sortQuery string) string {
 		return "hearts DESC"
 	} else if sortQuery == "viewed" {
 		return "views DESC"
+	} else if sortQuery == "hot" {
+		return "hot_score DESC, id DESC"
+	} else if sortQuery == "trending" {
+		return "trending_score DESC, id DESC"
 	}
 	return "hearts DESC, views DESC" // popular
 }
+
+// Appends an argument to a query argument list and returns its placeholder
+func addArg(args *[]interface{}, arg interface{}) string {
+	*args = append(*args, arg)
+	return "$" + strconv.Itoa(len(*args))
+}
+
+// Converts a nullable time into a json friendly value
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cache-Control policies of cacheable routes
+// Articles are short lived in shared caches so that hidden or deleted articles disappear quickly
+const (
+	noStorePolicy     = "no-store"
+	articlePolicy     = "public, max-age=60"
+	userArticlePolicy = "private, no-cache" // revalidated since the bookmark of the user may change
+	tagsPolicy        = "public, max-age=3600"
+	searchPolicy      = "public, max-age=30"
+)
+
+// Middleware marking responses as not cacheable, cacheable handlers override it on success
+// Errors and responses for signed in users must never be stored by a shared cache
+func noStoreMiddleware(c *gin.Context) {
+	c.Header("Cache-Control", noStorePolicy)
+	c.Next()
+}
+
+// Weak etag of an article revision, its hearts and the power of two its views are at
+// Views change with every request, so only a new bucket (ex. from 64 to 128 views) changes the etag
+// Weak because views within a bucket may change without the etag changing
+func articleETag(article *articleResponse) string {
+	return fmt.Sprintf(`W/"%d-%d-%d-%d"`, article.Id, article.Updated.UnixNano(), article.Hearts, bits.Len(uint(article.Views)))
+}
+
+// Weak etag of an article as seen by a signed in user, changing with their bookmark
+func userArticleETag(article *articleResponse) string {
+	return strings.TrimSuffix(articleETag(article), `"`) + "-" + toSHA1(fmt.Sprint(article.Bookmark.Bookmarked, article.Bookmark.Folder))[:8] + `"`
+}
+
+// Sets the cache headers of a successful response
+// Returns true (after answering 304) if the copy of the client is still fresh
+// Only etags are validated, no response has a modification time covering all of its content
+func notModified(c *gin.Context, policy string, etag string) bool {
+	c.Header("Cache-Control", policy)
+	c.Header("ETag", etag)
+
+	fresh := etagMatches(c.GetHeader("If-None-Match"), etag)
+	if fresh {
+		c.Status(http.StatusNotModified)
+	}
+	return fresh
+}
+
+// Weak comparison of an etag with the list of an If-None-Match header
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Responds with a json body whose etag is the hash of the body
+// Saves the bandwidth (not the queries) of responses without a revision to derive an etag from
+func cachedJSON(c *gin.Context, policy string, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		panic(err)
+	}
+	if notModified(c, policy, `W/"`+toSHA1(string(data))+`"`) {
+		return
+	}
+	c.Data(200, "application/json; charset=utf-8", data)
+}
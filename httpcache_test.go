@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArticleETag(t *testing.T) {
+	updated := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	base := articleResponse{Id: 1, Updated: updated, Hearts: 4, Views: 70}
+
+	tests := []struct {
+		name    string
+		change  func(article *articleResponse)
+		changed bool
+	}{
+		{"same article", func(article *articleResponse) {}, false},
+		{"views within their power of two", func(article *articleResponse) { article.Views = 127 }, false},
+		{"views at the next power of two", func(article *articleResponse) { article.Views = 128 }, true},
+		{"hearted", func(article *articleResponse) { article.Hearts++ }, true},
+		{"unhearted", func(article *articleResponse) { article.Hearts-- }, true},
+		{"updated", func(article *articleResponse) { article.Updated = updated.Add(time.Second) }, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			article := base
+			test.change(&article)
+			if changed := articleETag(&article) != articleETag(&base); changed != test.changed {
+				t.Errorf("etag changed %v, want %v", changed, test.changed)
+			}
+
+			article.Bookmark = &bookmarkResponse{Bookmarked: true, Folder: "reading"}
+			other := base
+			other.Bookmark = &bookmarkResponse{Bookmarked: true, Folder: "reading"}
+			if changed := userArticleETag(&article) != userArticleETag(&other); changed != test.changed {
+				t.Errorf("user etag changed %v, want %v", changed, test.changed)
+			}
+			other.Bookmark = &bookmarkResponse{}
+			if userArticleETag(&article) == userArticleETag(&other) {
+				t.Error("user etag did not change with the bookmark")
+			}
+		})
+	}
+}
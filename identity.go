@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const googleUserInfoUrl = "https://www.googleapis.com/oauth2/v2/userinfo"
+
+// Returned by an IdentityProvider when a code or access token is not accepted
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// A signed in user as reported by the identity provider
+type userInfo struct {
+	Id            string `json:"id"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	Picture       string `json:"picture"`
+	VerifiedEmail bool   `json:"verified_email"`
+}
+
+// Signs users in and identifies them by access token
+type IdentityProvider interface {
+	AuthCodeURL(state string) string
+	Exchange(ctx context.Context, code string) (accessToken string, err error)
+	UserInfo(ctx context.Context, accessToken string) (*userInfo, error)
+}
+
+// Identity provider using google oauth
+type googleIdentityProvider struct {
+	oauth  *oauth2.Config
+	client *http.Client
+}
+
+func newGoogleIdentityProvider(clientId string, clientSecret string, redirectUrl string) *googleIdentityProvider {
+	return &googleIdentityProvider{
+		oauth: &oauth2.Config{
+			RedirectURL:  redirectUrl,
+			ClientID:     clientId,
+			ClientSecret: clientSecret,
+			Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"},
+			Endpoint:     google.Endpoint,
+		},
+		client: newTracedHttpClient(10 * time.Second),
+	}
+}
+
+func (g *googleIdentityProvider) AuthCodeURL(state string) string {
+	return g.oauth.AuthCodeURL(state)
+}
+
+func (g *googleIdentityProvider) Exchange(ctx context.Context, code string) (string, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, g.client)
+	token, err := g.oauth.Exchange(ctx, code)
+	if err != nil {
+		return "", errInvalidCredentials
+	}
+	return token.AccessToken, nil
+}
+
+func (g *googleIdentityProvider) UserInfo(ctx context.Context, accessToken string) (*userInfo, error) {
+	// The token goes in a header rather than the url so it never ends up in traces
+	request, err := http.NewRequestWithContext(ctx, "GET", googleUserInfoUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	response, err := g.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var data struct {
+		userInfo
+		Error interface{} `json:"error"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&data)
+	if err != nil || data.Error != nil {
+		return nil, errInvalidCredentials
+	}
+	return &data.userInfo, nil
+}
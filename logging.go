@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	requestIdHeader    = "X-Request-ID"
+	maxRequestIdLength = 128
+	redacted           = "[REDACTED]"
+)
+
+var (
+	requestIdRgx = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	emailRgx     = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+)
+
+// Attribute keys whose values never make it into the logs
+var sensitiveLogKeys = map[string]bool{
+	"token":         true,
+	"accesstoken":   true,
+	"access_token":  true,
+	"authorization": true,
+	"code":          true,
+	"secret":        true,
+	"password":      true,
+	"email":         true,
+	"psqlinfo":      true,
+}
+
+// Creates a leveled logger writing json or text lines to w, redacting tokens and emails
+func newLogger(w io.Writer, level slog.Level, format string) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	if format == "text" {
+		return slog.New(slog.NewTextHandler(w, options))
+	}
+	return slog.New(slog.NewJSONHandler(w, options))
+}
+
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if sensitiveLogKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, redacted)
+	}
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, emailRgx.ReplaceAllString(attr.Value.String(), redacted))
+	case slog.KindAny:
+		// Errors and other values may embed emails (ex. "user x@y.com not found")
+		if err, ok := attr.Value.Any().(error); ok {
+			return slog.String(attr.Key, emailRgx.ReplaceAllString(err.Error(), redacted))
+		}
+	}
+	return attr
+}
+
+// Parses a log level name (debug, info, warn or error)
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(name))
+	if err != nil {
+		return level, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Middleware accepting or generating a request id and attaching a logger carrying it to the request
+func (s *Server) requestIdMiddleware(c *gin.Context) {
+	requestId := c.GetHeader(requestIdHeader)
+	if len(requestId) > maxRequestIdLength || !requestIdRgx.MatchString(requestId) {
+		requestId = newRequestId()
+	}
+	logger := s.logger.With("requestId", requestId)
+	if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+		logger = logger.With("traceId", span.TraceID().String())
+	}
+	c.Set("requestId", requestId)
+	c.Set("logger", logger)
+	c.Header(requestIdHeader, requestId)
+	c.Next()
+}
+
+// Logger of the request, carrying its request id (must come after s.requestIdMiddleware)
+func requestLogger(c *gin.Context) *slog.Logger {
+	if logger, ok := c.Get("logger"); ok {
+		return logger.(*slog.Logger)
+	}
+	return slog.Default()
+}
+
+// Middleware logging every request once it is served
+// Only the path is logged since query strings may carry sign in codes
+func accessLogMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	level := slog.LevelInfo
+	if c.Writer.Status() >= 500 {
+		level = slog.LevelError
+	}
+	requestLogger(c).Log(c.Request.Context(), level, "request",
+		"method", c.Request.Method,
+		"route", c.FullPath(),
+		"path", c.Request.URL.Path,
+		"status", c.Writer.Status(),
+		"latencyMs", time.Since(start).Milliseconds(),
+		"clientIp", c.ClientIP(),
+		"bytes", c.Writer.Size(),
+	)
+}
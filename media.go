@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+)
+
+var imgSrcRgx = regexp.MustCompile(`src="([^"]*)"`)
+
+// A single image in an article's gallery
+type mediaItem struct {
+	Url     string `json:"url" binding:"required"`
+	Caption string `json:"caption" binding:"max=300"`
+	Credit  string `json:"credit" binding:"max=100"`
+	Alt     string `json:"alt" binding:"max=150"`
+}
+
+func (s *Server) isStoreImageUrl(url string) bool {
+	return s.config.ImageUrlRgx.MatchString(url)
+}
+
+// Checks that an img tag only references images in our image store
+func (s *Server) isStoreImageTag(tag string) bool {
+	srcs := imgSrcRgx.FindAllStringSubmatch(tag, -1)
+	for _, src := range srcs {
+		if !s.isStoreImageUrl(src[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Replaces the media list of an article
+func (s *Server) saveArticleMedia(ctx context.Context, ex execer, articleId int, media []mediaItem) error {
+	_, err := ex.ExecContext(ctx, `DELETE FROM article_media WHERE article_id=$1`, articleId)
+	if err != nil {
+		return err
+	}
+	q := `INSERT INTO article_media (article_id, position, url, caption, credit, alt) VALUES ($1, $2, $3, $4, $5, $6)`
+	for i, item := range media {
+		_, err = ex.ExecContext(ctx, q, articleId, i, item.Url, item.Caption, item.Credit, item.Alt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fetches the ordered media list of an article
+func (s *Server) fetchArticleMedia(ctx context.Context, articleId int) ([]mediaItem, error) {
+	q := `SELECT url, caption, credit, alt FROM article_media WHERE article_id=$1 ORDER BY position`
+	rows, err := s.store.QueryContext(ctx, q, articleId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	media := []mediaItem{}
+	for rows.Next() {
+		var item mediaItem
+		err = rows.Scan(&item.Url, &item.Caption, &item.Credit, &item.Alt)
+		if err != nil {
+			return nil, err
+		}
+		media = append(media, item)
+	}
+	err = rows.Err()
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return media, nil
+}
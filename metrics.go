@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by route template, method and status.",
+	}, []string{"route", "method", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route template and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Database call latency by operation (select, insert, update, delete, begin, ...).",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"operation"})
+	dbQueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_query_errors_total",
+		Help: "Failed database calls by operation.",
+	}, []string{"operation"})
+
+	blobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "blob_request_duration_seconds",
+		Help:    "Image store latency by operation and result.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation", "result"})
+	blobBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "blob_size_bytes",
+		Help:    "Size of images uploaded to and downloaded from the image store.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 8),
+	}, []string{"operation"})
+
+	identityDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "identity_request_duration_seconds",
+		Help:    "Identity provider latency by operation and result.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation", "result"})
+
+	articlesCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "articles_created_total",
+		Help: "Articles created or replaced, by whether the spam filter held them.",
+	}, []string{"held"})
+	heartsToggled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hearts_toggled_total",
+		Help: "Hearts added or removed.",
+	}, []string{"action"})
+	searches = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "searches_total",
+		Help: "Article searches.",
+	})
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_requests_total",
+		Help: "Cache lookups by result (hit or miss).",
+	}, []string{"result"})
+	captchaFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "captcha_failures_total",
+		Help: "Captchas that were rejected or could not be verified.",
+	}, []string{"reason"})
+)
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// First keyword of a query (select, insert, ...) used as the operation label
+func queryOperation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	return strings.ToLower(fields[0])
+}
+
+func observeQuery(operation string, start time.Time, err error) {
+	dbQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil && err != sql.ErrNoRows {
+		dbQueryErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// Registers a collector exporting the connection pool statistics of db
+func registerDBStats(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "postgres"))
+}
+
+// Middleware recording count and latency of every request, labelled by route template
+func metricsMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequests.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+	httpDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+}
+
+// Serves metrics in the prometheus format
+// Requires "Authorization: Bearer <METRICS_TOKEN>" when a token is configured
+func (s *Server) metricsHandler() gin.HandlerFunc {
+	handler := promhttp.Handler()
+	return func(c *gin.Context) {
+		if s.config.MetricsToken != "" {
+			token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.MetricsToken)) != 1 {
+				c.AbortWithStatus(401)
+				return
+			}
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// Blob store recording latency and sizes of another blob store
+type metricsBlobStore struct {
+	BlobStore
+}
+
+// Counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+func (b metricsBlobStore) Put(ctx context.Context, key string, body io.Reader) error {
+	start := time.Now()
+	counter := &countingReader{reader: body}
+	err := b.BlobStore.Put(ctx, key, counter)
+	blobDuration.WithLabelValues("upload", resultLabel(err)).Observe(time.Since(start).Seconds())
+	if err == nil {
+		blobBytes.WithLabelValues("upload").Observe(float64(counter.count))
+	}
+	return err
+}
+
+func (b metricsBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	start := time.Now()
+	data, err := b.BlobStore.Get(ctx, key)
+	blobDuration.WithLabelValues("download", resultLabel(err)).Observe(time.Since(start).Seconds())
+	if err == nil {
+		blobBytes.WithLabelValues("download").Observe(float64(len(data)))
+	}
+	return data, err
+}
+
+func (b metricsBlobStore) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := b.BlobStore.Delete(ctx, key)
+	blobDuration.WithLabelValues("delete", resultLabel(err)).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// Identity provider recording latency and failures of another identity provider
+type metricsIdentityProvider struct {
+	IdentityProvider
+}
+
+func (p metricsIdentityProvider) Exchange(ctx context.Context, code string) (string, error) {
+	start := time.Now()
+	accessToken, err := p.IdentityProvider.Exchange(ctx, code)
+	identityDuration.WithLabelValues("exchange", resultLabel(err)).Observe(time.Since(start).Seconds())
+	return accessToken, err
+}
+
+func (p metricsIdentityProvider) UserInfo(ctx context.Context, accessToken string) (*userInfo, error) {
+	start := time.Now()
+	user, err := p.IdentityProvider.UserInfo(ctx, accessToken)
+	identityDuration.WithLabelValues("userinfo", resultLabel(err)).Observe(time.Since(start).Seconds())
+	return user, err
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Arbitrary key of the advisory lock held while migrating, shared by all instances
+const migrationLockKey = 7263540912
+
+var migrationFileRgx = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// A versioned schema change and the statements undoing it
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// Reads the embedded migrations ordered by version
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		match := migrationFileRgx.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		body, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: match[2]}
+			byVersion[version] = m
+		}
+		if m.name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %s and %s", version, m.name, match[2])
+		}
+		if match[3] == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	migrations := []migration{}
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// Applies and reverts migrations, recording them in schema_migrations
+type migrator struct {
+	db         *sql.DB
+	migrations []migration
+	logger     *slog.Logger
+}
+
+func newMigrator(db *sql.DB, logger *slog.Logger) (*migrator, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return &migrator{db, migrations, logger}, nil
+}
+
+// Runs fn on a connection holding the migration lock so parallel instances don't race
+func (m *migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT NOT NULL PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// Versions already applied and when
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// Runs the statements of a migration and records it in a single transaction
+func runMigration(ctx context.Context, conn *sql.Conn, statements string, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, statements)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Applies all pending migrations in order and returns how many were applied
+func (m *migrator) Up(ctx context.Context) (int, error) {
+	count := 0
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.version]; ok {
+				continue
+			}
+			q := `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`
+			err = runMigration(ctx, conn, mig.up, q, mig.version, mig.name)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s failed: %w", mig.version, mig.name, err)
+			}
+			m.logger.Info("applied migration", "version", mig.version, "name", mig.name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Reverts the last steps applied migrations and returns how many were reverted
+func (m *migrator) Down(ctx context.Context, steps int) (int, error) {
+	count := 0
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.version]; !ok {
+				continue
+			}
+			q := `DELETE FROM schema_migrations WHERE version=$1`
+			err = runMigration(ctx, conn, mig.down, q, mig.version)
+			if err != nil {
+				return fmt.Errorf("reverting migration %d_%s failed: %w", mig.version, mig.name, err)
+			}
+			m.logger.Info("reverted migration", "version", mig.version, "name", mig.name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Prints every known migration and whether it has been applied
+func (m *migrator) Status(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			status := "pending"
+			if appliedAt, ok := applied[mig.version]; ok {
+				status = "applied " + appliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%-20s %s\n", mig.version, mig.name, status)
+		}
+		return nil
+	})
+}
+
+// Counts embedded migrations not yet applied to the store
+func pendingMigrations(ctx context.Context, store Store) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	rows, err := store.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		err = rows.Scan(&version)
+		if err != nil {
+			return 0, err
+		}
+		applied[version] = true
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, mig := range migrations {
+		if !applied[mig.version] {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// Runs the migrate subcommand (ex. "migrate up", "migrate down -steps 2", "migrate status")
+// Only needs PSQL_INFO, so it can run before the rest of the configuration exists
+func migrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down|status [-config file] [-steps n]")
+	}
+	command := args[0]
+	flags := flag.NewFlagSet("migrate "+command, flag.ContinueOnError)
+	configFile := flags.String("config", "", "env file to load (defaults to "+defaultConfigFile+" if it exists)")
+	steps := flags.Int("steps", 1, "number of migrations to revert (down only)")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	err = loadConfigFile(*configFile)
+	if err != nil {
+		return err
+	}
+	psqlInfo := os.Getenv("PSQL_INFO")
+	if psqlInfo == "" {
+		return errors.New("PSQL_INFO is required")
+	}
+
+	logger := newLogger(os.Stderr, slog.LevelInfo, "text")
+	db, err := connectToDB(psqlInfo, 0, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	m, err := newMigrator(db, logger)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch command {
+	case "up":
+		count, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Info("migrated database", "applied", count)
+	case "down":
+		if *steps < 1 {
+			return errors.New("steps must be positive")
+		}
+		count, err := m.Down(ctx, *steps)
+		if err != nil {
+			return err
+		}
+		logger.Info("migrated database", "reverted", count)
+	case "status":
+		return m.Status(ctx)
+	default:
+		return fmt.Errorf("unknown migrate command %q", command)
+	}
+	return nil
+}
@@ -1,4 +1,4 @@
-CREATE TABLE articles (
+CREATE TABLE IF NOT EXISTS articles (
     id BIGSERIAL NOT NULL PRIMARY KEY,
     author VARCHAR(75) NOT NULL,
     author_google_id VARCHAR(25) NOT NULL,
@@ -12,21 +12,23 @@ CREATE TABLE articles (
     vector tsvector
 );
 
-CREATE TABLE tags (
+CREATE TABLE IF NOT EXISTS tags (
     tag VARCHAR(25) NOT NULL PRIMARY KEY
 );
 
-CREATE TABLE hearts (
+CREATE TABLE IF NOT EXISTS hearts (
     id BIGSERIAL NOT NULL PRIMARY KEY,
     articleId BIGINT REFERENCES articles(id) NOT NULL,
     userId VARCHAR(25) NOT NULL
 );
 
-INSERT INTO tags (tag) VALUES ('science');
-INSERT INTO tags (tag) VALUES ('sports');
-INSERT INTO tags (tag) VALUES ('entertainment');
-INSERT INTO tags (tag) VALUES ('education');
-INSERT INTO tags (tag) VALUES ('politics');
-INSERT INTO tags (tag) VALUES ('opinion');
-INSERT INTO tags (tag) VALUES ('buisness');
-INSERT INTO tags (tag) VALUES ('gaming');
\ No newline at end of file
+INSERT INTO tags (tag) VALUES
+    ('science'),
+    ('sports'),
+    ('entertainment'),
+    ('education'),
+    ('politics'),
+    ('opinion'),
+    ('buisness'),
+    ('gaming')
+ON CONFLICT (tag) DO NOTHING;
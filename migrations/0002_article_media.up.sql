@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS article_media (
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    article_id BIGINT REFERENCES articles(id) NOT NULL,
+    position INT NOT NULL,
+    url VARCHAR(75) NOT NULL,
+    caption VARCHAR(300) NOT NULL DEFAULT '',
+    credit VARCHAR(100) NOT NULL DEFAULT '',
+    alt VARCHAR(150) NOT NULL DEFAULT ''
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS articles_location_idx;
+
+ALTER TABLE articles DROP COLUMN IF EXISTS place_name;
+ALTER TABLE articles DROP COLUMN IF EXISTS longitude;
+ALTER TABLE articles DROP COLUMN IF EXISTS latitude;
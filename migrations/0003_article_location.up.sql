@@ -0,0 +1,5 @@
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION;
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION;
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS place_name VARCHAR(75);
+
+CREATE INDEX IF NOT EXISTS articles_location_idx ON articles (latitude, longitude);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS warnings;
+DROP TABLE IF EXISTS moderation_log;
+DROP TABLE IF EXISTS reports;
+
+ALTER TABLE articles DROP COLUMN IF EXISTS hidden;
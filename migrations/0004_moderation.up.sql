@@ -0,0 +1,31 @@
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS hidden BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS reports (
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    article_id BIGINT REFERENCES articles(id) NOT NULL,
+    reporter_id VARCHAR(25) NOT NULL,
+    reason VARCHAR(25) NOT NULL,
+    details VARCHAR(500) NOT NULL DEFAULT '',
+    status VARCHAR(10) NOT NULL DEFAULT 'open',
+    created TIMESTAMP NOT NULL DEFAULT NOW(),
+    resolved TIMESTAMP,
+    UNIQUE (article_id, reporter_id)
+);
+
+CREATE TABLE IF NOT EXISTS moderation_log (
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    moderator VARCHAR(75) NOT NULL,
+    article_id BIGINT NOT NULL,
+    report_id BIGINT,
+    action VARCHAR(10) NOT NULL,
+    note VARCHAR(500) NOT NULL DEFAULT '',
+    created TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS warnings (
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    user_id VARCHAR(25) NOT NULL,
+    article_id BIGINT NOT NULL,
+    note VARCHAR(500) NOT NULL DEFAULT '',
+    created TIMESTAMP NOT NULL DEFAULT NOW()
+);
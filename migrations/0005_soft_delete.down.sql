@@ -0,0 +1,2 @@
+ALTER TABLE articles DROP COLUMN IF EXISTS deleted_by;
+ALTER TABLE articles DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,2 @@
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(75);
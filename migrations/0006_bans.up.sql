@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS bans (
+    user_id VARCHAR(40) NOT NULL PRIMARY KEY,
+    google_id VARCHAR(25),
+    reason VARCHAR(500) NOT NULL DEFAULT '',
+    expires TIMESTAMP,
+    hide_articles BOOLEAN NOT NULL DEFAULT FALSE,
+    appeal_note VARCHAR(1000) NOT NULL DEFAULT '',
+    banned_by VARCHAR(75) NOT NULL,
+    created TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS banned_words (
+    word VARCHAR(50) NOT NULL PRIMARY KEY,
+    risk REAL NOT NULL DEFAULT 1
+);
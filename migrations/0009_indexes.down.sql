@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS hearts_article_user_idx;
+DROP INDEX IF EXISTS articles_author_google_id_idx;
+DROP INDEX IF EXISTS articles_created_idx;
+DROP INDEX IF EXISTS articles_vector_idx;
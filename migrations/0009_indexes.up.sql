@@ -0,0 +1,7 @@
+CREATE INDEX IF NOT EXISTS articles_vector_idx ON articles USING GIN (vector);
+CREATE INDEX IF NOT EXISTS articles_created_idx ON articles (created);
+CREATE INDEX IF NOT EXISTS articles_author_google_id_idx ON articles (author_google_id);
+
+-- A user can only heart an article once
+DELETE FROM hearts a USING hearts b WHERE a.id > b.id AND a.articleId = b.articleId AND a.userId = b.userId;
+CREATE UNIQUE INDEX IF NOT EXISTS hearts_article_user_idx ON hearts (articleId, userId);
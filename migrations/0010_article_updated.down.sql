@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS articles_updated ON articles;
+DROP FUNCTION IF EXISTS articles_touch_updated();
+ALTER TABLE articles DROP COLUMN IF EXISTS updated;
@@ -0,0 +1,18 @@
+-- Time of the last change to the content of an article, used for etags and Last-Modified
+-- Views and hearts are not content, counting them must not invalidate cached copies
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS updated TIMESTAMP NOT NULL DEFAULT NOW();
+UPDATE articles SET updated = created;
+
+CREATE OR REPLACE FUNCTION articles_touch_updated() RETURNS trigger AS $$
+BEGIN
+    IF (NEW.author, NEW.image_url, NEW.title, NEW.body, NEW.tags, NEW.latitude, NEW.longitude, NEW.place_name)
+        IS DISTINCT FROM (OLD.author, OLD.image_url, OLD.title, OLD.body, OLD.tags, OLD.latitude, OLD.longitude, OLD.place_name) THEN
+        NEW.updated = NOW();
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS articles_updated ON articles;
+CREATE TRIGGER articles_updated BEFORE UPDATE ON articles
+    FOR EACH ROW EXECUTE PROCEDURE articles_touch_updated();
@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS articles_trending_score_idx;
+DROP INDEX IF EXISTS articles_hot_score_idx;
+ALTER TABLE articles
+    DROP COLUMN IF EXISTS ranked_at,
+    DROP COLUMN IF EXISTS ranked_views,
+    DROP COLUMN IF EXISTS view_velocity,
+    DROP COLUMN IF EXISTS trending_score,
+    DROP COLUMN IF EXISTS hot_score;
+
+DROP INDEX IF EXISTS hearts_article_created_idx;
+ALTER TABLE hearts DROP COLUMN IF EXISTS created;
@@ -0,0 +1,17 @@
+-- When a heart was given, for the heart velocity of trending articles
+-- Existing hearts are dated to their article, they are not recent
+ALTER TABLE hearts ADD COLUMN IF NOT EXISTS created TIMESTAMP;
+UPDATE hearts SET created = articles.created FROM articles WHERE articles.id = hearts.articleId AND hearts.created IS NULL;
+ALTER TABLE hearts ALTER COLUMN created SET DEFAULT NOW(), ALTER COLUMN created SET NOT NULL;
+CREATE INDEX IF NOT EXISTS hearts_article_created_idx ON hearts (articleId, created);
+
+-- Time decayed scores recomputed by the ranking job, along with the view velocity they are based on
+ALTER TABLE articles
+    ADD COLUMN IF NOT EXISTS hot_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS trending_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS view_velocity DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS ranked_views INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS ranked_at TIMESTAMP;
+UPDATE articles SET ranked_views = views, ranked_at = NOW();
+CREATE INDEX IF NOT EXISTS articles_hot_score_idx ON articles (hot_score DESC);
+CREATE INDEX IF NOT EXISTS articles_trending_score_idx ON articles (trending_score DESC);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS hearts_user_idx;
+DROP TABLE IF EXISTS recommendations;
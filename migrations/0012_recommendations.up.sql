@@ -0,0 +1,12 @@
+-- Articles recommended to each user, recomputed from the hearts by the recommendation job
+CREATE TABLE IF NOT EXISTS recommendations (
+    user_id VARCHAR(25) NOT NULL,
+    article_id BIGINT REFERENCES articles(id) NOT NULL,
+    score DOUBLE PRECISION NOT NULL,
+    computed TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, article_id)
+);
+CREATE INDEX IF NOT EXISTS recommendations_user_score_idx ON recommendations (user_id, score DESC);
+
+-- The job reads the hearts of each user
+CREATE INDEX IF NOT EXISTS hearts_user_idx ON hearts (userId);
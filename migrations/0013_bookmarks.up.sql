@@ -0,0 +1,9 @@
+-- Private bookmarks of users, optionally sorted into folders
+CREATE TABLE IF NOT EXISTS bookmarks (
+    user_id VARCHAR(25) NOT NULL,
+    article_id BIGINT REFERENCES articles(id) NOT NULL,
+    folder VARCHAR(50) NOT NULL DEFAULT '',
+    created TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, article_id)
+);
+CREATE INDEX IF NOT EXISTS bookmarks_user_created_idx ON bookmarks (user_id, created DESC);
@@ -0,0 +1,7 @@
+-- Google ids of authors by their salted id, so that bans resolve them with one indexed query
+-- Filled when articles are written, the api adds earlier authors (the salt is not known here)
+CREATE TABLE IF NOT EXISTS user_ids (
+    user_id VARCHAR(40) NOT NULL PRIMARY KEY,
+    google_id VARCHAR(25) NOT NULL UNIQUE
+);
+INSERT INTO user_ids (user_id, google_id) SELECT user_id, google_id FROM bans WHERE google_id IS NOT NULL ON CONFLICT DO NOTHING;
@@ -0,0 +1 @@
+-- The recounted hearts are correct, there is nothing to undo
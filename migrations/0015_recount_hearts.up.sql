@@ -0,0 +1,3 @@
+-- 0009 removed duplicate hearts without correcting the counters they had incremented
+UPDATE articles SET hearts = (SELECT COUNT(*) FROM hearts WHERE hearts.articleId = articles.id)
+WHERE hearts <> (SELECT COUNT(*) FROM hearts WHERE hearts.articleId = articles.id);
@@ -0,0 +1,2 @@
+-- Period of each bucket, so that buckets are swept once idle for their own period rather than a day
+ALTER TABLE rate_limits ADD COLUMN IF NOT EXISTS period DOUBLE PRECISION NOT NULL DEFAULT 86400;
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxReportDetailsLength = 500
+
+var (
+	reportReasons     = [7]string{"spam", "harassment", "hate", "violence", "misinformation", "copyright", "other"}
+	moderationActions = [5]string{"dismiss", "publish", "hide", "delete", "warn"}
+)
+
+// Implemented by both Store and *Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func isReportReason(reason string) bool {
+	for _, r := range reportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+func isModerationAction(action string) bool {
+	for _, a := range moderationActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) isModerator(email interface{}) bool {
+	if email == s.config.AdminEmail {
+		return true
+	}
+	for _, moderatorEmail := range s.config.ModeratorEmails {
+		if email == moderatorEmail {
+			return true
+		}
+	}
+	return false
+}
+
+func logModeration(ctx context.Context, ex execer, moderator interface{}, articleId interface{}, reportId interface{}, action string, note string) error {
+	q := `INSERT INTO moderation_log (moderator, article_id, report_id, action, note) VALUES ($1, $2, $3, $4, $5)`
+	_, err := ex.ExecContext(ctx, q, moderator, articleId, reportId, action, note)
+	return err
+}
+
+// Middleware to only allow moderators (must come after s.accessTokenMiddleware)
+func (s *Server) moderatorMiddleware(c *gin.Context) {
+	defer handleError(c)
+	email, _ := c.Get("email")
+	if !s.isModerator(email) {
+		panic(noPermission)
+	}
+	c.Next()
+}
+
+// Reports an article and hides it once enough distinct users reported it
+func (s *Server) reportArticleHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	reporterId, _ := c.Get("id")
+	reason := strings.ToLower(c.DefaultPostForm("reason", ""))
+	details := strings.TrimSpace(c.DefaultPostForm("details", ""))
+
+	// Check validity of id, reason and details
+	articleId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || articleId < 0 {
+		panic(invalidNumber)
+	}
+	if !isReportReason(reason) || len(details) > maxReportDetailsLength {
+		panic(invalidReport)
+	}
+
+	// Check if article exists
+	var exists bool
+	q := `SELECT exists(SELECT 1 FROM articles WHERE id=$1 AND deleted_at IS NULL) AS "exists"`
+	err = s.store.QueryRowContext(ctx, q, articleId).Scan(&exists)
+	if err != nil {
+		panic(err)
+	}
+	if !exists {
+		panic(notFound)
+	}
+
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	// Add report (a user can only report an article once)
+	q = `INSERT INTO reports (article_id, reporter_id, reason, details) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (article_id, reporter_id) DO NOTHING`
+	_, err = tx.ExecContext(ctx, q, articleId, reporterId, reason, details)
+	if err != nil {
+		panic(err)
+	}
+
+	// Hide article if the threshold was reached, only the report that actually hid it is logged
+	var reports int
+	q = `SELECT COUNT(DISTINCT reporter_id) FROM reports WHERE article_id=$1 AND status='open'`
+	err = tx.QueryRowContext(ctx, q, articleId).Scan(&reports)
+	if err != nil {
+		panic(err)
+	}
+	hid := false
+	if s.config.ReportHideThreshold > 0 && reports >= s.config.ReportHideThreshold {
+		q = `UPDATE articles SET hidden = TRUE WHERE id=$1 AND NOT hidden`
+		result, err := tx.ExecContext(ctx, q, articleId)
+		if err != nil {
+			panic(err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			panic(err)
+		}
+		if affected == 1 {
+			hid = true
+			err = logModeration(ctx, tx, "system", articleId, nil, "hide", fmt.Sprintf("automatically hidden after %d reports", reports))
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	if hid {
+		s.invalidateArticle(ctx, articleId)
+	}
+
+	c.JSON(201, reportResponse{articleId, reason})
+}
+
+// Responds with the moderation queue
+func (s *Server) reportsHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	// Check validity of limit and offset
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "25"))
+	if err != nil || limit < 1 || limit > s.config.ModerationMaxLimit {
+		panic(invalidNumber)
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		panic(invalidNumber)
+	}
+	status := c.DefaultQuery("status", "open")
+
+	q := `SELECT reports.id, reports.article_id, articles.title, articles.hidden, reports.reason, reports.details, reports.status, reports.created
+	FROM reports JOIN articles ON articles.id = reports.article_id
+	WHERE reports.status=$1 AND articles.deleted_at IS NULL
+	ORDER BY reports.created LIMIT $2 OFFSET $3`
+	rows, err := s.store.QueryContext(ctx, q, status, limit, offset)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	reports := []report{}
+	for rows.Next() {
+		var id int
+		var articleId int
+		var title string
+		var hidden bool
+		var reason string
+		var details string
+		var status string
+		var created time.Time
+		err = rows.Scan(&id, &articleId, &title, &hidden, &reason, &details, &status, &created)
+		if err != nil {
+			panic(err)
+		}
+		reports = append(reports, report{id, articleId, title, hidden, reason, details, status, created})
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	c.JSON(200, reportsResponse{len(reports), reports})
+}
+
+// Resolves a report and every other open report on the same article
+// Dismissing only closes the report, other reasons to hide the article (ex. a spam hold) stay open
+// Publishing un-hides the article and dismisses all of its reports
+func (s *Server) resolveReportHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	moderator, _ := c.Get("email")
+	action := strings.ToLower(c.DefaultPostForm("action", ""))
+	note := strings.TrimSpace(c.DefaultPostForm("note", ""))
+
+	// Check validity of id, action and note
+	reportId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || reportId < 0 {
+		panic(invalidNumber)
+	}
+	if !isModerationAction(action) || len(note) > maxReportDetailsLength {
+		panic(invalidReport)
+	}
+
+	// Find reported article
+	var articleId int
+	var authorGoogleId string
+	q := `SELECT articles.id, articles.author_google_id FROM reports JOIN articles ON articles.id = reports.article_id WHERE reports.id=$1`
+	err = s.store.QueryRowContext(ctx, q, reportId).Scan(&articleId, &authorGoogleId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			panic(notFound)
+		} else {
+			panic(err)
+		}
+	}
+
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	// Dismissing closes only this report, every other action all open reports of the article
+	status := "resolved"
+	q = `UPDATE reports SET status=$1, resolved=NOW() WHERE article_id=$2 AND status='open'`
+	target := articleId
+	switch action {
+	case "dismiss":
+		status = "dismissed"
+		q = `UPDATE reports SET status=$1, resolved=NOW() WHERE id=$2 AND status='open'`
+		target = reportId
+	case "publish":
+		status = "dismissed"
+		_, err = tx.ExecContext(ctx, `UPDATE articles SET hidden = FALSE WHERE id=$1`, articleId)
+	case "hide":
+		_, err = tx.ExecContext(ctx, `UPDATE articles SET hidden = TRUE WHERE id=$1`, articleId)
+	case "warn":
+		_, err = tx.ExecContext(ctx, `INSERT INTO warnings (user_id, article_id, note) VALUES ($1, $2, $3)`, authorGoogleId, articleId, note)
+	case "delete":
+		err = softDeleteArticle(ctx, tx, articleId, moderator)
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = tx.ExecContext(ctx, q, status, target)
+	if err != nil {
+		panic(err)
+	}
+
+	err = logModeration(ctx, tx, moderator, articleId, reportId, action, note)
+	if err != nil {
+		panic(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	// Dismissing and warning leave the article as it is
+	if action == "publish" || action == "hide" || action == "delete" {
+		s.invalidateArticle(ctx, articleId)
+	}
+	c.JSON(200, resolveReportResponse{articleId, action})
+}
+
+// Responds with the moderation log
+func (s *Server) moderationLogHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	// Check validity of limit and offset
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "25"))
+	if err != nil || limit < 1 || limit > s.config.ModerationMaxLimit {
+		panic(invalidNumber)
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		panic(invalidNumber)
+	}
+
+	q := `SELECT id, moderator, article_id, report_id, action, note, created FROM moderation_log
+	ORDER BY created DESC LIMIT $1 OFFSET $2`
+	rows, err := s.store.QueryContext(ctx, q, limit, offset)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	entries := []moderationLogEntry{}
+	for rows.Next() {
+		var id int
+		var moderator string
+		var articleId int
+		var reportId sql.NullInt64
+		var action string
+		var note string
+		var created time.Time
+		err = rows.Scan(&id, &moderator, &articleId, &reportId, &action, &note, &created)
+		if err != nil {
+			panic(err)
+		}
+		entry := moderationLogEntry{Id: id, Moderator: moderator, ArticleId: articleId, Action: action, Note: note, Created: created}
+		if reportId.Valid {
+			entry.ReportId = &reportId.Int64
+		}
+		entries = append(entries, entry)
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	c.JSON(200, moderationLogResponse{len(entries), entries})
+}
+
+// Responds with the moderation warnings a user received
+func (s *Server) userWarningsHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	userId, _ := c.Get("id")
+
+	q := `SELECT article_id, note, created FROM warnings WHERE user_id=$1 ORDER BY created DESC`
+	rows, err := s.store.QueryContext(ctx, q, userId)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	warnings := []warning{}
+	for rows.Next() {
+		var articleId int
+		var note string
+		var created time.Time
+		err = rows.Scan(&articleId, &note, &created)
+		if err != nil {
+			panic(err)
+		}
+		warnings = append(warnings, warning{articleId, note, created})
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	c.JSON(200, warningsResponse{len(warnings), warnings})
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const apiPrefix = "/v1"
+
+var ginParamRgx = regexp.MustCompile(`[:*](\w+)`)
+
+// A query, path or form parameter of an operation
+type apiParam struct {
+	name        string
+	in          string // query, path, form or file
+	typ         string // string, integer, number or boolean
+	required    bool
+	description string
+}
+
+// Documentation of a route mounted under apiPrefix
+type apiOperation struct {
+	method   string
+	path     string // gin syntax, relative to apiPrefix
+	summary  string
+	auth     bool
+	params   []apiParam
+	status   int
+	response interface{} // zero value of the response body type, nil for binary responses
+}
+
+func queryParam(name string, typ string, description string) apiParam {
+	return apiParam{name, "query", typ, false, description}
+}
+
+func pathParam(name string, typ string) apiParam {
+	return apiParam{name, "path", typ, true, ""}
+}
+
+func formParam(name string, typ string, required bool, description string) apiParam {
+	return apiParam{name, "form", typ, required, description}
+}
+
+var (
+	limitParams = []apiParam{
+		queryParam("limit", "integer", "Number of results"),
+		queryParam("offset", "integer", "Number of results to skip"),
+	}
+	periodParam = queryParam("period", "string", "day, week, month, year or all")
+	sortParam   = queryParam("sort", "string", "new, hearted, viewed, popular, hot or trending")
+)
+
+// Every operation of the api, checked against the router and the handlers by TestOpenAPIMatchesRouter
+var apiOperations = []apiOperation{
+	{"GET", "/loginUrl", "Gets login url to google", false, nil, 200, loginUrlResponse{}},
+	{"GET", "/accessToken", "Gets access token via state and code", false, []apiParam{
+		{"state", "query", "string", true, ""},
+		{"code", "query", "string", true, ""},
+	}, 200, accessTokenResponse{}},
+	{"GET", "/userData", "Gets user data", true, nil, 200, userDataResponse{}},
+	{"GET", "/userArticles", "Gets articles of user", true, append([]apiParam{periodParam, sortParam}, limitParams...), 200, articleListResponse{}},
+	{"GET", "/recommended", "Gets articles recommended to the user from their hearts (hot articles until there are some)", true, limitParams, 200, articleListResponse{}},
+
+	{"POST", "/create", "Creates an article, or replaces one with replaceId", true, nil, 201, createArticleResponse{}},
+	{"GET", "/articles/:id", "Gets article (with the bookmark of signed in users)", false, []apiParam{pathParam("id", "integer")}, 200, articleResponse{}},
+	{"DELETE", "/articles/:id", "Deletes article (restorable until it is purged)", true, []apiParam{pathParam("id", "integer")}, 200, articleIdResponse{}},
+	{"POST", "/articles/:id/restore", "Restores a deleted article", true, []apiParam{pathParam("id", "integer")}, 200, articleIdResponse{}},
+	{"GET", "/articles/:id/related", "Gets articles related to an article (excluding the own articles of signed in users)", false, []apiParam{
+		pathParam("id", "integer"),
+		queryParam("limit", "integer", "Number of results"),
+	}, 200, articleListResponse{}},
+	{"GET", "/tags", "Gets tags", false, nil, 200, tagsResponse{}},
+
+	{"GET", "/articles/:id/hearted", "Gets whether the user hearted an article", true, []apiParam{pathParam("id", "integer")}, 200, heartedResponse{}},
+	{"POST", "/heart", "Hearts an article, or removes the heart if it exists", true, nil, 200, heartedResponse{}},
+
+	{"POST", "/articles/:id/bookmark", "Bookmarks an article, or moves its bookmark to another folder", true, []apiParam{pathParam("id", "integer")}, 200, bookmarkResponse{}},
+	{"DELETE", "/articles/:id/bookmark", "Removes the bookmark of an article", true, []apiParam{pathParam("id", "integer")}, 200, bookmarkResponse{}},
+	{"GET", "/bookmarks", "Gets bookmarked articles of user, most recently bookmarked first", true, append([]apiParam{
+		queryParam("folder", "string", "Only bookmarks of this folder, empty for bookmarks without a folder"),
+	}, limitParams...), 200, articleListResponse{}},
+	{"GET", "/bookmarks/folders", "Gets bookmark folders of user", true, nil, 200, bookmarkFoldersResponse{}},
+
+	{"POST", "/uploadImage", "Uploads an image", true, []apiParam{
+		{"image", "file", "string", true, "Png, jpeg or gif image"},
+	}, 200, imageUrlResponse{}},
+	{"GET", "/images/:imageName", "Gets an image", false, []apiParam{pathParam("imageName", "string")}, 200, nil},
+
+	{"POST", "/articles/:id/reports", "Reports an article", true, []apiParam{
+		pathParam("id", "integer"),
+		formParam("reason", "string", true, strings.Join(reportReasons[:], ", ")),
+		formParam("details", "string", false, ""),
+	}, 201, reportResponse{}},
+	{"GET", "/userWarnings", "Gets moderation warnings of user", true, nil, 200, warningsResponse{}},
+	{"GET", "/moderation/reports", "Gets moderation queue (moderators only)", true, append([]apiParam{
+		queryParam("status", "string", "open, resolved or dismissed"),
+	}, limitParams...), 200, reportsResponse{}},
+	{"POST", "/moderation/reports/:id/resolve", "Resolves reports of an article (moderators only)", true, []apiParam{
+		pathParam("id", "integer"),
+		formParam("action", "string", true, strings.Join(moderationActions[:], ", ")),
+		formParam("note", "string", false, ""),
+	}, 200, resolveReportResponse{}},
+	{"GET", "/moderation/log", "Gets moderation log (moderators only)", true, limitParams, 200, moderationLogResponse{}},
+
+	{"GET", "/moderation/bannedWords", "Gets banned words (moderators only)", true, nil, 200, bannedWordsResponse{}},
+	{"POST", "/moderation/bannedWords", "Adds a banned word with a spam risk (moderators only)", true, []apiParam{
+		formParam("word", "string", true, ""),
+		formParam("risk", "number", false, ""),
+	}, 201, bannedWord{}},
+	{"DELETE", "/moderation/bannedWords/:word", "Removes a banned word (moderators only)", true, []apiParam{pathParam("word", "string")}, 200, removedWordResponse{}},
+
+	{"GET", "/userBan", "Gets ban or suspension of user", true, nil, 200, userBanResponse{}},
+	{"POST", "/userBan/appeal", "Appeals ban or suspension of user", true, []apiParam{
+		formParam("note", "string", true, ""),
+	}, 200, appealResponse{}},
+	{"GET", "/moderation/bans", "Gets active bans (moderators only)", true, nil, 200, bansResponse{}},
+	{"POST", "/moderation/bans", "Bans a user, or suspends them for a number of hours (moderators only)", true, []apiParam{
+		formParam("userId", "string", true, "Salted user id"),
+		formParam("reason", "string", false, ""),
+		formParam("hours", "integer", false, "0 bans permanently"),
+		formParam("hideArticles", "boolean", false, ""),
+	}, 201, banResponse{}},
+	{"DELETE", "/moderation/bans/:userId", "Lifts a ban (moderators only)", true, []apiParam{pathParam("userId", "string")}, 200, userIdResponse{}},
+
+	{"GET", "/search", "Gets list of articles", false, append([]apiParam{
+		queryParam("q", "string", "Search words"),
+		queryParam("near", "string", "lat,lng to search around"),
+		queryParam("radius", "number", "Radius around near in km"),
+		periodParam,
+		sortParam,
+	}, limitParams...), 200, articleListResponse{}},
+	{"GET", "/map", "Gets clustered article locations", false, []apiParam{
+		{"bbox", "query", "string", true, "minLng,minLat,maxLng,maxLat"},
+		periodParam,
+	}, 200, mapResponse{}},
+
+	{"GET", "/openapi.json", "Gets this document", false, nil, 200, nil},
+}
+
+// Request bodies of operations taking json or form data, by method and path
+// Form values of non string fields (ex. the media array) are json encoded
+var apiRequests = map[string]interface{}{
+	"POST /create":                createArticleRequest{},
+	"POST /heart":                 heartRequest{},
+	"POST /articles/:id/bookmark": bookmarkRequest{},
+}
+
+// Unique id of an operation (ex. "getArticlesIdHearted")
+func operationId(op apiOperation) string {
+	id := strings.ToLower(op.method)
+	for _, word := range strings.FieldsFunc(op.path, func(r rune) bool { return r == '/' || r == ':' || r == '.' }) {
+		id += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return id
+}
+
+func openAPIPath(ginPath string) string {
+	return ginParamRgx.ReplaceAllString(ginPath, "{$1}")
+}
+
+// Builds json schemas of go types, collecting named structs as components
+type schemaBuilder struct {
+	components map[string]interface{}
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := b.schema(t.Elem())
+		if _, ok := s["$ref"]; ok {
+			// Siblings of $ref are ignored
+			return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if _, ok := b.components[t.Name()]; !ok {
+			b.components[t.Name()] = nil // reserved against recursion
+			properties := map[string]interface{}{}
+			required := []string{}
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				name, omitempty := jsonName(field)
+				if name == "" {
+					continue
+				}
+				schema := b.schema(field.Type)
+				rules, hasRules := field.Tag.Lookup("binding")
+				if hasRules {
+					// Requests are required by their validation rules, not by their encoding
+					omitempty = !bindingConstraints(rules, field.Type, schema)
+				}
+				properties[name] = schema
+				if !omitempty {
+					required = append(required, name)
+				}
+			}
+			b.components[t.Name()] = map[string]interface{}{"type": "object", "properties": properties, "required": required}
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// Adds the min and max binding rules of a field to its schema, returns whether the field is required
+func bindingConstraints(rules string, t reflect.Type, schema map[string]interface{}) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	minKey, maxKey := "minimum", "maximum"
+	switch t.Kind() {
+	case reflect.String:
+		minKey, maxKey = "minLength", "maxLength"
+	case reflect.Slice, reflect.Array:
+		minKey, maxKey = "minItems", "maxItems"
+	}
+	required := false
+	for _, rule := range strings.Split(rules, ",") {
+		if rule == "dive" {
+			break // the remaining rules apply to the items
+		}
+		name, param, _ := strings.Cut(rule, "=")
+		value, err := strconv.ParseFloat(param, 64)
+		switch {
+		case name == "required":
+			required = true
+		case name == "min" && err == nil:
+			schema[minKey] = value
+		case name == "max" && err == nil:
+			schema[maxKey] = value
+		}
+	}
+	return required
+}
+
+// Json name of a struct field and whether it may be omitted ("" if it is never encoded)
+func jsonName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := strings.Split(field.Tag.Get("json"), ",")
+	if tag[0] == "-" {
+		return "", false
+	}
+	name := tag[0]
+	if name == "" {
+		name = field.Name
+	}
+	return name, len(tag) > 1 && tag[1] == "omitempty"
+}
+
+// Builds the openapi 3 document of apiOperations
+func buildOpenAPI() ([]byte, error) {
+	builder := &schemaBuilder{components: map[string]interface{}{}}
+	errorSchema := builder.schema(reflect.TypeOf(errorBody{}))
+	paths := map[string]map[string]interface{}{}
+	for _, op := range apiOperations {
+		operation := map[string]interface{}{
+			"summary":     op.summary,
+			"operationId": operationId(op),
+		}
+
+		parameters := []interface{}{}
+		formProperties := map[string]interface{}{}
+		formRequired := []string{}
+		contentType := "application/x-www-form-urlencoded"
+		for _, param := range op.params {
+			schema := map[string]interface{}{"type": param.typ}
+			switch param.in {
+			case "form", "file":
+				if param.in == "file" {
+					schema["format"] = "binary"
+					contentType = "multipart/form-data"
+				}
+				if param.description != "" {
+					schema["description"] = param.description
+				}
+				formProperties[param.name] = schema
+				if param.required {
+					formRequired = append(formRequired, param.name)
+				}
+			default:
+				parameter := map[string]interface{}{"name": param.name, "in": param.in, "required": param.required, "schema": schema}
+				if param.description != "" {
+					parameter["description"] = param.description
+				}
+				parameters = append(parameters, parameter)
+			}
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if request, ok := apiRequests[op.method+" "+op.path]; ok {
+			requestType := reflect.TypeOf(request)
+			schema := builder.schema(requestType)
+			// Bodies without required fields may be left out
+			component := builder.components[requestType.Name()].(map[string]interface{})
+			operation["requestBody"] = map[string]interface{}{
+				"required": len(component["required"].([]string)) > 0,
+				"content": map[string]interface{}{
+					"application/json":                  map[string]interface{}{"schema": schema},
+					"application/x-www-form-urlencoded": map[string]interface{}{"schema": schema},
+					"multipart/form-data":               map[string]interface{}{"schema": schema},
+				},
+			}
+		} else if len(formProperties) > 0 {
+			operation["requestBody"] = map[string]interface{}{
+				"required": len(formRequired) > 0,
+				"content": map[string]interface{}{
+					contentType: map[string]interface{}{
+						"schema": map[string]interface{}{"type": "object", "properties": formProperties, "required": formRequired},
+					},
+				},
+			}
+		}
+		if op.auth {
+			operation["security"] = []interface{}{map[string]interface{}{"bearer": []string{}}}
+		}
+
+		success := map[string]interface{}{"description": "Success"}
+		if op.response != nil {
+			success["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": builder.schema(reflect.TypeOf(op.response))},
+			}
+		}
+		operation["responses"] = map[string]interface{}{
+			fmt.Sprint(op.status): success,
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": errorSchema}},
+			},
+		}
+
+		p := openAPIPath(op.path)
+		if paths[p] == nil {
+			paths[p] = map[string]interface{}{}
+		}
+		paths[p][strings.ToLower(op.method)] = operation
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Crowd Report API",
+			"version": "1",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": apiPrefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": builder.components,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "Google access token"},
+			},
+		},
+	})
+}
+
+// Middleware marking the unversioned alias of a route as deprecated in favour of its /v1 path
+func deprecatedMiddleware(c *gin.Context) {
+	c.Header("Deprecation", "true")
+	c.Header("Link", "<"+apiPrefix+c.Request.URL.Path+">; rel=\"successor-version\"")
+	c.Next()
+}
+
+// Serves the openapi document
+// The document is checked against the router by TestOpenAPIMatchesRouter
+func (s *Server) openAPIHandler(c *gin.Context) {
+	defer handleError(c)
+
+	s.openAPIOnce.Do(func() {
+		s.openAPI, s.openAPIErr = buildOpenAPI()
+	})
+	if s.openAPIErr != nil {
+		panic(s.openAPIErr)
+	}
+	c.Data(200, "application/json; charset=utf-8", s.openAPI)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Methods of gin.Context reading a request parameter, by where the parameter is
+var paramReaders = map[string]string{
+	"Param":           "path",
+	"Query":           "query",
+	"DefaultQuery":    "query",
+	"GetQuery":        "query",
+	"QueryArray":      "query",
+	"PostForm":        "form",
+	"DefaultPostForm": "form",
+	"GetPostForm":     "form",
+	"FormFile":        "file",
+}
+
+// What a handler reads from requests, found in its source and the functions it passes its context to
+type handlerUsage struct {
+	params      map[string]bool // "in name"
+	requestType string          // type bound by bindRequest, empty if none
+	responses   map[string]bool // "status type" of the json responses whose type is known
+}
+
+// Parses the functions of the package, by name (methods by their name alone)
+func parsePackageFuncs(t *testing.T) map[string]*ast.FuncDecl {
+	t.Helper()
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	funcs := map[string]*ast.FuncDecl{}
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil {
+				funcs[fn.Name.Name] = fn
+			}
+		}
+	}
+	return funcs
+}
+
+// Names of the *gin.Context parameters of a function
+func contextParams(fn *ast.FuncType) map[string]bool {
+	names := map[string]bool{}
+	for _, field := range fn.Params.List {
+		star, ok := field.Type.(*ast.StarExpr)
+		if !ok {
+			continue
+		}
+		selector, ok := star.X.(*ast.SelectorExpr)
+		if !ok || selector.Sel.Name != "Context" {
+			continue
+		}
+		for _, name := range field.Names {
+			names[name.Name] = true
+		}
+	}
+	return names
+}
+
+func collectUsage(funcs map[string]*ast.FuncDecl, fn *ast.FuncDecl, usage *handlerUsage, visited map[string]bool) {
+	if visited[fn.Name.Name] {
+		return
+	}
+	visited[fn.Name.Name] = true
+	contexts := contextParams(fn.Type)
+
+	// Declared types of local variables, to resolve bindRequest(c, &req)
+	varTypes := map[string]string{}
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		if spec, ok := n.(*ast.ValueSpec); ok {
+			if ident, ok := spec.Type.(*ast.Ident); ok {
+				for _, name := range spec.Names {
+					varTypes[name.Name] = ident.Name
+				}
+			}
+		}
+		return true
+	})
+
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		var callee string
+		switch fun := call.Fun.(type) {
+		case *ast.Ident:
+			callee = fun.Name
+		case *ast.SelectorExpr:
+			callee = fun.Sel.Name
+			if receiver, ok := fun.X.(*ast.Ident); ok && contexts[receiver.Name] {
+				if in, ok := paramReaders[callee]; ok && len(call.Args) > 0 {
+					if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+						name, _ := strconv.Unquote(lit.Value)
+						usage.params[in+" "+name] = true
+					}
+				}
+				if callee == "JSON" && len(call.Args) == 2 {
+					if lit, ok := call.Args[0].(*ast.BasicLit); ok {
+						if typ := exprType(call.Args[1], varTypes); typ != "" {
+							usage.responses[lit.Value+" "+typ] = true
+						}
+					}
+				}
+				return true
+			}
+		}
+		if callee == "cachedJSON" && len(call.Args) == 3 {
+			if typ := exprType(call.Args[2], varTypes); typ != "" {
+				usage.responses["200 "+typ] = true
+			}
+		}
+		if callee == "bindRequest" && len(call.Args) == 2 {
+			if unary, ok := call.Args[1].(*ast.UnaryExpr); ok {
+				if ident, ok := unary.X.(*ast.Ident); ok {
+					usage.requestType = varTypes[ident.Name]
+				}
+			}
+			return true
+		}
+		// Follow functions of the package given the context
+		for _, arg := range call.Args {
+			if ident, ok := arg.(*ast.Ident); ok && contexts[ident.Name] {
+				if callee, ok := funcs[callee]; ok {
+					collectUsage(funcs, callee, usage, visited)
+				}
+				break
+			}
+		}
+		return true
+	})
+}
+
+// Named type of a composite literal or of a declared variable, empty if unknown
+func exprType(expr ast.Expr, varTypes map[string]string) string {
+	switch e := expr.(type) {
+	case *ast.CompositeLit:
+		if ident, ok := e.Type.(*ast.Ident); ok {
+			return ident.Name
+		}
+	case *ast.UnaryExpr:
+		return exprType(e.X, varTypes)
+	case *ast.Ident:
+		return varTypes[e.Name]
+	}
+	return ""
+}
+
+// Name of the function of a gin handler (ex. "main.(*Server).searchHandler-fm")
+func handlerFuncName(handler string) string {
+	name := handler[strings.LastIndex(handler, ".")+1:]
+	return strings.TrimSuffix(name, "-fm")
+}
+
+func TestOpenAPIMatchesRouter(t *testing.T) {
+	s := NewServer(testConfig(t), nil, nil, nil, nil, newFakeClock(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	funcs := parsePackageFuncs(t)
+
+	documented := map[string]apiOperation{}
+	for _, op := range apiOperations {
+		documented[op.method+" "+apiPrefix+op.path] = op
+	}
+	mounted := map[string]bool{}
+	for _, route := range s.router.Routes() {
+		if !strings.HasPrefix(route.Path, apiPrefix+"/") {
+			continue
+		}
+		key := route.Method + " " + route.Path
+		mounted[key] = true
+		op, ok := documented[key]
+		if !ok {
+			t.Errorf("%s is not documented", key)
+			continue
+		}
+
+		fn, ok := funcs[handlerFuncName(route.Handler)]
+		if !ok {
+			t.Errorf("%s: handler %s not found", key, route.Handler)
+			continue
+		}
+		usage := &handlerUsage{params: map[string]bool{}, responses: map[string]bool{}}
+		collectUsage(funcs, fn, usage, map[string]bool{})
+
+		params := map[string]bool{}
+		for _, param := range op.params {
+			params[param.in+" "+param.name] = true
+		}
+		for _, match := range ginParamRgx.FindAllStringSubmatch(op.path, -1) {
+			if !params["path "+match[1]] {
+				t.Errorf("%s does not document path parameter %s", key, match[1])
+			}
+		}
+		for _, param := range sortedKeys(usage.params) {
+			if !params[param] {
+				t.Errorf("%s reads undocumented %s parameter", key, param)
+			}
+		}
+		for _, param := range sortedKeys(params) {
+			if !usage.params[param] {
+				t.Errorf("%s documents %s parameter its handler never reads", key, param)
+			}
+		}
+
+		documentedResponse := strconv.Itoa(op.status) + " "
+		if op.response != nil {
+			documentedResponse += reflect.TypeOf(op.response).Name()
+		}
+		for _, response := range sortedKeys(usage.responses) {
+			if response != documentedResponse {
+				t.Errorf("%s responds %s but documents %s", key, response, documentedResponse)
+			}
+		}
+
+		documentedRequest := ""
+		if request, ok := apiRequests[op.method+" "+op.path]; ok {
+			documentedRequest = reflect.TypeOf(request).Name()
+		}
+		if usage.requestType != documentedRequest {
+			t.Errorf("%s binds request body %q but documents %q", key, usage.requestType, documentedRequest)
+		}
+	}
+	for key := range documented {
+		if !mounted[key] {
+			t.Errorf("%s is documented but not mounted", key)
+		}
+	}
+}
+
+func TestOpenAPIDocumentIsServed(t *testing.T) {
+	s := NewServer(testConfig(t), nil, nil, nil, nil, newFakeClock(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", apiPrefix+"/openapi.json", nil))
+	if w.Code != 200 {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+	var document struct {
+		OpenAPI string                 `json:"openapi"`
+		Paths   map[string]interface{} `json:"paths"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(document.OpenAPI, "3.") || len(document.Paths) == 0 {
+		t.Fatalf("unexpected document: openapi %q with %d paths", document.OpenAPI, len(document.Paths))
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Parameters of the hot and trending scores
+const (
+	viewWeight        = 0.1  // a heart is worth 10 views
+	hotGravity        = 1.8  // how fast hot articles sink with age
+	trendingGravity   = 0.5  // trending articles sink slower, their velocity decays by itself
+	trendingWindow    = 24.0 // hours of hearts counted for the heart velocity
+	velocitySmoothing = 6.0  // hours after which a change in view velocity is mostly (63%) taken over
+	rankingRecent     = 72.0 // hours during which articles are rescored at every ranking
+	rankingMinScore   = 0.001
+	rankingTimeout    = 5 * time.Minute // of the ranking job, longer than DB_QUERY_TIMEOUT since it scores many articles
+)
+
+// Arbitrary key of the advisory lock held while ranking, so that instances never rank at the same time
+const rankingLockKey = 7263540914
+
+// Recomputes the scores of all articles (nil) whose scores can still change, or of a single article
+//
+// hot: (hearts + viewWeight * views + 1) / (ageHours + 2) ^ hotGravity
+// trending: (heartsPerHour + viewWeight * viewsPerHour) / (ageHours + 2) ^ trendingGravity
+//
+// Hearts per hour count the hearts of the trending window, views per hour are smoothed
+// over the views counted since the previous ranking since views are not timestamped
+//
+// Articles that are not recent, got no views or hearts in the trending window since they were ranked
+// and whose scores fell below rankingMinScore keep their scores, they only keep sinking below the others
+func (s *Server) rankArticles(ctx context.Context, ex execer, articleId interface{}) error {
+	q := `WITH ranked AS (
+		SELECT id,
+		EXTRACT(EPOCH FROM $1::timestamp - created) / 3600 AS age_hours,
+		GREATEST(EXTRACT(EPOCH FROM $1::timestamp - COALESCE(ranked_at, created)) / 3600, 0.01) AS elapsed_hours,
+		views - ranked_views AS new_views,
+		view_velocity,
+		(SELECT COUNT(*) FROM hearts WHERE hearts.articleId = articles.id AND hearts.created > $1::timestamp - $2::float8 * INTERVAL '1 hour') AS recent_hearts
+		FROM articles
+		WHERE deleted_at IS NULL AND ($3::bigint IS NULL OR id = $3::bigint)
+		AND ($3::bigint IS NOT NULL OR ranked_at IS NULL
+			OR created > $1::timestamp - $8::float8 * INTERVAL '1 hour'
+			OR views > ranked_views
+			OR hot_score > $9::float8 OR trending_score > $9::float8 OR view_velocity > $9::float8
+			OR EXISTS (SELECT 1 FROM hearts WHERE hearts.articleId = articles.id AND hearts.created > ranked_at - $2::float8 * INTERVAL '1 hour'))
+	), smoothed AS (
+		SELECT id, age_hours, recent_hearts,
+		view_velocity + (new_views / elapsed_hours - view_velocity) * (1 - EXP(-elapsed_hours / $4::float8)) AS view_velocity
+		FROM ranked
+	)
+	UPDATE articles SET
+		ranked_views = views,
+		ranked_at = $1::timestamp,
+		view_velocity = smoothed.view_velocity,
+		hot_score = (hearts + $5::float8 * views + 1) / POWER(GREATEST(smoothed.age_hours, 0) + 2, $6::float8),
+		trending_score = (smoothed.recent_hearts / $2::float8 + $5::float8 * smoothed.view_velocity) / POWER(GREATEST(smoothed.age_hours, 0) + 2, $7::float8)
+	FROM smoothed WHERE articles.id = smoothed.id`
+	_, err := ex.ExecContext(ctx, q, s.clock.Now(), trendingWindow, articleId, velocitySmoothing, viewWeight, hotGravity, trendingGravity,
+		rankingRecent, rankingMinScore)
+	return err
+}
+
+// Recomputes the scores of every article that can change (run periodically)
+// Instances take turns, two rankings at once would only smooth the view velocity over shorter steps
+func (s *Server) rankJob(ctx context.Context) error {
+	tx, err := s.store.WithTimeout(rankingTimeout).BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Released with the transaction
+	var locked bool
+	err = tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, rankingLockKey).Scan(&locked)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		s.logger.Info("skipped ranking articles", "reason", "another instance is ranking them")
+		return nil
+	}
+
+	err = s.rankArticles(ctx, tx, nil)
+	if err != nil {
+		return err
+	}
+	// Hot and trending listings catch up with the new scores within listingCacheTTL
+	return tx.Commit()
+}
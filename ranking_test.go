@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+)
+
+func TestRankJobLocked(t *testing.T) {
+	s := newTestServer(t)
+	s.db.OnQuery(`pg_try_advisory_xact_lock`, []string{"locked"}, []driver.Value{false})
+
+	err := s.rankJob(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.db.Statements(`UPDATE articles SET`)) != 0 {
+		t.Error("ranked articles without the lock")
+	}
+	if len(s.db.Statements(`ROLLBACK`)) != 1 {
+		t.Error("transaction holding no lock was not rolled back")
+	}
+}
+
+func TestRankJob(t *testing.T) {
+	s := newTestServer(t)
+	s.db.OnQuery(`pg_try_advisory_xact_lock`, []string{"locked"}, []driver.Value{true})
+
+	err := s.rankJob(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locks := s.db.Statements(`pg_try_advisory_xact_lock`); len(locks) != 1 || locks[0].args[0] != rankingLockKey {
+		t.Errorf("locks %v, want one on the ranking key", locks)
+	}
+	updates := s.db.Statements(`UPDATE articles SET`)
+	if len(updates) != 1 || updates[0].args[2] != nil || updates[0].args[7] != rankingRecent || updates[0].args[8] != rankingMinScore {
+		t.Fatalf("updates %v, want every article whose scores can change ranked", updates)
+	}
+	if len(s.db.Statements(`COMMIT`)) != 1 {
+		t.Error("scores were not committed")
+	}
+}
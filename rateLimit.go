@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultRateLimits = "create=5/1h,heart=60/1m,uploadImage=20/1h,search=120/1m"
+	rateLimitSweep    = 10 * time.Minute
+)
+
+// Token bucket holding up to capacity tokens, refilling capacity tokens per period
+type rateLimit struct {
+	capacity int
+	period   time.Duration
+}
+
+func (l rateLimit) perSecond() float64 {
+	return float64(l.capacity) / l.period.Seconds()
+}
+
+// Time until the bucket holding tokens has refilled to the given amount
+func (l rateLimit) timeUntil(tokens float64, target float64) time.Duration {
+	if tokens >= target {
+		return 0
+	}
+	return time.Duration(math.Ceil((target-tokens)/l.perSecond())) * time.Second
+}
+
+// Takes a token from the bucket of key and returns the tokens left
+type rateLimitStore interface {
+	Take(ctx context.Context, key string, limit rateLimit) (allowed bool, tokens float64, err error)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	period  time.Duration // time to refill from empty
+}
+
+// Rate limit store for single instance deployments
+type memoryRateLimitStore struct {
+	clock     Clock
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newMemoryRateLimitStore(clock Clock) *memoryRateLimitStore {
+	return &memoryRateLimitStore{clock: clock, buckets: map[string]*bucket{}, lastSweep: clock.Now()}
+}
+
+func (s *memoryRateLimitStore) Take(ctx context.Context, key string, limit rateLimit) (bool, float64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.clock.Now()
+	if now.Sub(s.lastSweep) > rateLimitSweep {
+		// Buckets idle for a whole period are full again so forgetting them changes nothing
+		for k, b := range s.buckets {
+			if now.Sub(b.updated) > b.period {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{float64(limit.capacity), now, limit.period}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.capacity), b.tokens+now.Sub(b.updated).Seconds()*limit.perSecond())
+	b.updated = now
+	if b.tokens < 1 {
+		return false, b.tokens, nil
+	}
+	b.tokens--
+	return true, b.tokens, nil
+}
+
+// Rate limit store shared by all instances through the database
+type postgresRateLimitStore struct {
+	store Store
+}
+
+func newPostgresRateLimitStore(store Store) *postgresRateLimitStore {
+	return &postgresRateLimitStore{store}
+}
+
+// Removes buckets idle for their whole period, they are full again so forgetting them changes nothing (run periodically)
+func (s *postgresRateLimitStore) Sweep(ctx context.Context) error {
+	_, err := s.store.ExecContext(ctx, `DELETE FROM rate_limits WHERE updated < NOW() - period * INTERVAL '1 second'`)
+	return err
+}
+
+func (s *postgresRateLimitStore) Take(ctx context.Context, key string, limit rateLimit) (bool, float64, error) {
+	refilled := `LEAST($2, rate_limits.tokens + EXTRACT(EPOCH FROM (NOW() - rate_limits.updated)) * $3)`
+	q := `INSERT INTO rate_limits (key, tokens, allowed, updated, period) VALUES ($1, $2 - 1, TRUE, NOW(), $4)
+	ON CONFLICT (key) DO UPDATE SET
+	period = $4,
+	allowed = ` + refilled + ` >= 1,
+	tokens = CASE WHEN ` + refilled + ` >= 1 THEN ` + refilled + ` - 1 ELSE ` + refilled + ` END,
+	updated = NOW()
+	RETURNING allowed, tokens`
+	var allowed bool
+	var tokens float64
+	err := s.store.QueryRowContext(ctx, q, key, float64(limit.capacity), limit.perSecond(), limit.period.Seconds()).Scan(&allowed, &tokens)
+	return allowed, tokens, err
+}
+
+// Parses rate limits per route (ex. "create=5/1h,search=120/1m")
+func parseRateLimits(limitsString string) (map[string]rateLimit, error) {
+	limits := map[string]rateLimit{}
+	for _, pair := range strings.Split(limitsString, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid rate limit %q", pair)
+		}
+		limitParts := strings.SplitN(parts[1], "/", 2)
+		if len(limitParts) != 2 {
+			return nil, fmt.Errorf("invalid rate limit %q", pair)
+		}
+		capacity, err := strconv.Atoi(limitParts[0])
+		if err != nil || capacity < 1 {
+			return nil, fmt.Errorf("invalid rate limit %q", pair)
+		}
+		period, err := time.ParseDuration(limitParts[1])
+		if err != nil || period <= 0 {
+			return nil, fmt.Errorf("invalid rate limit %q", pair)
+		}
+		limits[strings.TrimSpace(parts[0])] = rateLimit{capacity, period}
+	}
+	return limits, nil
+}
+
+// Middleware to rate limit a route by salted user id, or client ip for anonymous requests
+// (must come after s.accessTokenMiddleware on authorized routes)
+func (s *Server) rateLimitMiddleware(route string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer handleError(c)
+
+		limit, ok := s.config.RateLimits[route]
+		if !ok {
+			c.Next()
+			return
+		}
+		key := route + ":ip:" + s.clientIp(c)
+		if googleId, ok := c.Get("id"); ok {
+			key = route + ":user:" + s.saltedUserId(googleId)
+		}
+
+		allowed, tokens, err := s.rateLimiter.Take(c.Request.Context(), key, limit)
+		if err != nil {
+			// Fail open, an unavailable store should not take the api down
+			requestLogger(c).Warn("failed to take rate limit token", "route", route, "error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.capacity))
+		c.Header("RateLimit-Remaining", strconv.Itoa(int(tokens)))
+		c.Header("RateLimit-Reset", strconv.Itoa(int(limit.timeUntil(tokens, float64(limit.capacity)).Seconds())))
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(limit.timeUntil(tokens, 1).Seconds())))
+			panic(tooManyRequests)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMemoryRateLimitStoreSweepKeepsDrainedBuckets(t *testing.T) {
+	clock := newFakeClock()
+	store := newMemoryRateLimitStore(clock)
+	limit := rateLimit{5, time.Hour}
+	ctx := context.Background()
+
+	for i := 0; i < limit.capacity; i++ {
+		allowed, _, err := store.Take(ctx, "create:user", limit)
+		if err != nil || !allowed {
+			t.Fatalf("take %d: allowed %v, error %v", i, allowed, err)
+		}
+	}
+
+	// Past the sweep but far from refilled, one token at most
+	clock.Advance(11 * time.Minute)
+	allowed, _, err := store.Take(ctx, "create:user", limit)
+	if err != nil || allowed {
+		t.Fatalf("after 11 minutes: allowed %v, error %v, want refused", allowed, err)
+	}
+	allowed, _, err = store.Take(ctx, "create:user", limit)
+	if err != nil || allowed {
+		t.Fatalf("second take after 11 minutes: allowed %v, error %v, want refused", allowed, err)
+	}
+
+	// Once full again the bucket may be forgotten
+	clock.Advance(2 * time.Hour)
+	for i := 0; i < limit.capacity; i++ {
+		allowed, _, err := store.Take(ctx, "create:user", limit)
+		if err != nil || !allowed {
+			t.Fatalf("take %d after refilling: allowed %v, error %v", i, allowed, err)
+		}
+	}
+}
+
+func TestMemoryRateLimitStoreSweepForgetsFullBuckets(t *testing.T) {
+	clock := newFakeClock()
+	store := newMemoryRateLimitStore(clock)
+	limit := rateLimit{60, time.Minute}
+	ctx := context.Background()
+
+	store.Take(ctx, "heart:user", limit)
+	clock.Advance(11 * time.Minute)
+	store.Take(ctx, "heart:other", limit)
+	if _, ok := store.buckets["heart:user"]; ok {
+		t.Fatal("idle full bucket was not swept")
+	}
+}
+
+func TestClientIp(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{config: &Config{TrustedProxies: proxies}}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"direct", "203.0.113.7:4000", "", "203.0.113.7"},
+		{"spoofed forwarded for", "203.0.113.7:4000", "198.51.100.1", "203.0.113.7"},
+		{"behind proxy", "10.1.2.3:4000", "198.51.100.1", "198.51.100.1"},
+		{"spoofed behind proxy", "10.1.2.3:4000", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"behind proxies", "10.1.2.3:4000", "198.51.100.1, 192.168.1.1", "198.51.100.1"},
+		{"proxy without forwarded for", "192.168.1.1:4000", "", "192.168.1.1"},
+		{"garbage forwarded for", "10.1.2.3:4000", "nonsense", "10.1.2.3"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/v1/search", nil)
+			c.Request.RemoteAddr = test.remoteAddr
+			if test.forwarded != "" {
+				c.Request.Header.Set("X-Forwarded-For", test.forwarded)
+			}
+			if ip := s.clientIp(c); ip != test.want {
+				t.Errorf("client ip %s, want %s", ip, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesRejectsGarbage(t *testing.T) {
+	for _, proxy := range []string{"nonsense", "10.0.0.0/33", "300.1.1.1"} {
+		if _, err := parseTrustedProxies([]string{proxy}); err == nil {
+			t.Errorf("accepted %q", proxy)
+		}
+	}
+}
+
+func TestPostgresRateLimitStoreSweepsByBucketPeriod(t *testing.T) {
+	db := &scriptedDB{}
+	store := newPostgresRateLimitStore(db.Store())
+	ctx := context.Background()
+	db.OnQuery(`INSERT INTO rate_limits`, []string{"allowed", "tokens"}, []driver.Value{true, 59.0})
+
+	_, _, err := store.Take(ctx, "heart:user", rateLimit{capacity: 60, period: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	takes := db.Statements(`INSERT INTO rate_limits`)
+	if len(takes) != 1 || takes[0].args[3] != 60.0 {
+		t.Fatalf("takes %v, want the bucket period of 60 seconds stored", takes)
+	}
+
+	err = store.Sweep(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sweeps := db.Statements(`DELETE FROM rate_limits`)
+	if len(sweeps) != 1 || !strings.Contains(sweeps[0].query, "period * INTERVAL '1 second'") {
+		t.Errorf("sweeps %v, want buckets swept after their own period", sweeps)
+	}
+}
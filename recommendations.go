@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Parameters of the recommendations computed from the hearts of each user
+const (
+	recommendationTagWeight     = 1.0  // per share of the hearts of the user given to a tag of the article
+	recommendationCoheartWeight = 1.0  // per ln(1 + shared hearts) of each user who hearted the article
+	recommendationActiveDays    = 90.0 // users who gave no heart for longer get no new recommendations
+	recommendationMaxAgeDays    = 30.0 // older articles are not recommended
+	recommendationsPerUser      = 100
+	recommendationTimeout       = time.Hour // of the recommend command, the job uses DB_QUERY_TIMEOUT
+)
+
+// Arbitrary key of the advisory lock held while recomputing, so that instances and the command never run it twice at once
+const recommendationLockKey = 7263540913
+
+// Returned by recommendArticles when another instance or the command is already recomputing
+var errRecommendationsRunning = errors.New("recommendations are already being recomputed")
+
+// Replaces the recommendations of every recently active user, returns the number of recommendations
+//
+// Tag affinity: tagWeight * the share of the hearts of the user given to each tag of the article
+// Co-hearts: coheartWeight * ln(1 + hearts shared with the user) of each user who hearted the article
+//
+// Articles of the user and articles the user hearted are never recommended
+func recommendArticles(ctx context.Context, store Store, now time.Time) (int64, error) {
+	tx, err := store.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Released with the transaction
+	var locked bool
+	err = tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, recommendationLockKey).Scan(&locked)
+	if err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, errRecommendationsRunning
+	}
+
+	// Users who are no longer active keep nothing, their recommendations would only get staler
+	_, err = tx.ExecContext(ctx, `DELETE FROM recommendations`)
+	if err != nil {
+		return 0, err
+	}
+	q := `WITH users AS (
+		SELECT DISTINCT userId AS user_id FROM hearts WHERE created > $1::timestamp - $2::float8 * INTERVAL '1 day'
+	), liked AS (
+		SELECT hearts.userId AS user_id, hearts.articleId AS article_id, articles.tags
+		FROM hearts JOIN users ON users.user_id = hearts.userId JOIN articles ON articles.id = hearts.articleId
+	), affinity AS (
+		SELECT user_id, tag, COUNT(*)::float8 / SUM(COUNT(*)) OVER (PARTITION BY user_id) AS share
+		FROM liked, unnest(string_to_array(tags, ',')) AS tag
+		GROUP BY user_id, tag
+	), neighbours AS (
+		SELECT liked.user_id, hearts.userId AS neighbour_id, LN(1 + COUNT(*)) AS weight
+		FROM liked JOIN hearts ON hearts.articleId = liked.article_id AND hearts.userId <> liked.user_id
+		GROUP BY liked.user_id, hearts.userId
+	), candidates AS (
+		SELECT id, author_google_id, string_to_array(tags, ',') AS tags FROM articles
+		WHERE created > $1::timestamp - $3::float8 * INTERVAL '1 day' AND ` + visibleArticleSql + `
+	), tag_scores AS (
+		SELECT affinity.user_id, candidates.id AS article_id, SUM(affinity.share) AS score
+		FROM affinity JOIN candidates ON affinity.tag = ANY(candidates.tags)
+		GROUP BY affinity.user_id, candidates.id
+	), coheart_scores AS (
+		SELECT neighbours.user_id, hearts.articleId AS article_id, SUM(neighbours.weight) AS score
+		FROM neighbours JOIN hearts ON hearts.userId = neighbours.neighbour_id JOIN candidates ON candidates.id = hearts.articleId
+		GROUP BY neighbours.user_id, hearts.articleId
+	), scored AS (
+		SELECT user_id, article_id, SUM(score) AS score FROM (
+			SELECT user_id, article_id, $4::float8 * score AS score FROM tag_scores
+			UNION ALL SELECT user_id, article_id, $5::float8 * score FROM coheart_scores
+		) signals GROUP BY user_id, article_id
+	), ranked AS (
+		SELECT scored.user_id, scored.article_id, scored.score,
+		ROW_NUMBER() OVER (PARTITION BY scored.user_id ORDER BY scored.score DESC, scored.article_id DESC) AS rank
+		FROM scored JOIN candidates ON candidates.id = scored.article_id
+		WHERE candidates.author_google_id <> scored.user_id
+		AND NOT EXISTS (SELECT 1 FROM hearts WHERE hearts.userId = scored.user_id AND hearts.articleId = scored.article_id)
+	)
+	INSERT INTO recommendations (user_id, article_id, score, computed)
+	SELECT user_id, article_id, score, $1::timestamp FROM ranked WHERE rank <= $6`
+	result, err := tx.ExecContext(ctx, q, now, recommendationActiveDays, recommendationMaxAgeDays,
+		recommendationTagWeight, recommendationCoheartWeight, recommendationsPerUser)
+	if err != nil {
+		return 0, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+// Recomputes the recommendations (run periodically if RECOMMENDATION_INTERVAL is set)
+func (s *Server) recommendJob(ctx context.Context) error {
+	count, err := recommendArticles(ctx, s.store, s.clock.Now())
+	if errors.Is(err, errRecommendationsRunning) {
+		s.logger.Info("skipped recomputing recommendations", "reason", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.logger.Info("recomputed recommendations", "recommendations", count)
+	return nil
+}
+
+// Runs the recommendation job once instead of serving (ex. "application recommend" from cron)
+// Preferred over RECOMMENDATION_INTERVAL, the job inside the api is bound by DB_QUERY_TIMEOUT
+func recommendCommand(args []string) error {
+	flags := flag.NewFlagSet("recommend", flag.ContinueOnError)
+	configFile := flags.String("config", "", "env file to load (defaults to "+defaultConfigFile+" if it exists)")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	err = loadConfigFile(*configFile)
+	if err != nil {
+		return err
+	}
+	psqlInfo := os.Getenv("PSQL_INFO")
+	if psqlInfo == "" {
+		return errors.New("PSQL_INFO is required")
+	}
+
+	logger := newLogger(os.Stderr, slog.LevelInfo, "text")
+	db, err := connectToDB(psqlInfo, 0, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	count, err := recommendArticles(context.Background(), newSQLStore(db, recommendationTimeout), time.Now())
+	if err != nil {
+		return err
+	}
+	logger.Info("recomputed recommendations", "recommendations", count)
+	return nil
+}
+
+// Responds with the articles recommended to the user, best first
+// Users without recommendations yet (ex. no hearts) get the hot articles instead
+func (s *Server) recommendedHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	userId := c.GetString("id")
+
+	// Check validity of limit and offset
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > s.config.SearchMaxLimit {
+		panic(invalidNumber)
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		panic(invalidNumber)
+	}
+
+	// Articles hearted, hidden or deleted since the job ran are skipped
+	q := `SELECT articles.id, articles.author, articles.image_url, articles.title, articles.tags, articles.views, articles.hearts, articles.created
+	FROM recommendations JOIN articles ON articles.id = recommendations.article_id
+	WHERE recommendations.user_id = $1 AND ` + visibleArticleSql + `
+	AND NOT EXISTS (SELECT 1 FROM hearts WHERE hearts.articleId = articles.id AND hearts.userId = $1)
+	ORDER BY recommendations.score DESC, articles.id DESC LIMIT $2 OFFSET $3`
+	articles, err := s.loadArticleList(ctx, q, userId, limit, offset)
+	if err != nil {
+		panic(err)
+	}
+
+	if articles.(articleListResponse).Count == 0 {
+		var exists bool
+		q = `SELECT exists(SELECT 1 FROM recommendations WHERE user_id=$1) AS "exists"`
+		err = s.store.QueryRowContext(ctx, q, userId).Scan(&exists)
+		if err != nil {
+			panic(err)
+		}
+		if !exists {
+			q = `SELECT id, author, image_url, title, tags, views, hearts, created FROM articles
+			WHERE author_google_id <> $1 AND ` + visibleArticleSql + `
+			AND NOT EXISTS (SELECT 1 FROM hearts WHERE hearts.articleId = articles.id AND hearts.userId = $1)
+			ORDER BY ` + determineSort("hot") + ` LIMIT $2 OFFSET $3`
+			articles, err = s.loadArticleList(ctx, q, userId, limit, offset)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	c.JSON(200, articles)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+func TestRecommendArticlesLocked(t *testing.T) {
+	db := &scriptedDB{}
+	db.OnQuery(`pg_try_advisory_xact_lock`, []string{"locked"}, []driver.Value{false})
+
+	_, err := recommendArticles(context.Background(), db.Store(), newFakeClock().Now())
+	if !errors.Is(err, errRecommendationsRunning) {
+		t.Errorf("error %v, want errRecommendationsRunning", err)
+	}
+	if len(db.Statements(`DELETE FROM recommendations`)) != 0 || len(db.Statements(`INSERT INTO recommendations`)) != 0 {
+		t.Error("recomputed recommendations without the lock")
+	}
+	if len(db.Statements(`ROLLBACK`)) != 1 {
+		t.Error("transaction holding no lock was not rolled back")
+	}
+}
+
+func TestRecommendArticles(t *testing.T) {
+	db := &scriptedDB{}
+	db.OnQuery(`pg_try_advisory_xact_lock`, []string{"locked"}, []driver.Value{true})
+	db.OnExec(`INSERT INTO recommendations`, 12)
+
+	count, err := recommendArticles(context.Background(), db.Store(), newFakeClock().Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 12 {
+		t.Errorf("count %d, want 12", count)
+	}
+	if locks := db.Statements(`pg_try_advisory_xact_lock`); len(locks) != 1 || locks[0].args[0] != recommendationLockKey {
+		t.Errorf("locks %v, want one on the recommendation key", locks)
+	}
+	if len(db.Statements(`COMMIT`)) != 1 {
+		t.Error("recommendations were not committed")
+	}
+}
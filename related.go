@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Weights of the signals relating two articles
+const (
+	relatedTextWeight  = 10.0 // per ts_rank of the title of one article in the other, mostly below 0.1
+	relatedHeartWeight = 2.0  // per ln(1 + users who hearted both)
+	relatedTagWeight   = 0.5  // per shared tag
+)
+
+const (
+	relatedCacheTTL    = 10 * time.Minute
+	relatedCandidates  = 32 // cached per article, before excluding the articles of the viewer
+	relatedRecentDays  = 30 // age of the popular articles filling up weakly related lists
+	relatedDefaultSize = 6
+	relatedPolicy      = "max-age=300"
+)
+
+// A related article along with its author, kept to exclude the articles of the viewer
+type relatedArticle struct {
+	articleSummary
+	AuthorGoogleId string `json:"authorGoogleId"`
+}
+
+// Responds with articles similar to an article, by text, co-hearting users and shared tags
+// Signed in viewers do not get their own articles
+func (s *Server) relatedArticlesHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 0 {
+		panic(invalidNumber)
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(relatedDefaultSize)))
+	if err != nil || limit < 1 || limit > s.config.SearchMaxLimit {
+		panic(invalidNumber)
+	}
+
+	var candidates []relatedArticle
+	key := fmt.Sprintf("related:%s:%s:%d", s.generation(ctx, articlesGeneration), s.generation(ctx, listingsGeneration), id)
+	err = s.cached(ctx, key, relatedCacheTTL, &candidates, func(ctx context.Context) (interface{}, error) {
+		return s.loadRelatedArticles(ctx, id)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			panic(notFound)
+		} else {
+			panic(err)
+		}
+	}
+
+	viewerId := c.GetString("id")
+	articles := []articleSummary{}
+	for _, candidate := range candidates {
+		if len(articles) == limit {
+			break
+		}
+		if viewerId != "" && candidate.AuthorGoogleId == viewerId {
+			continue
+		}
+		articles = append(articles, candidate.articleSummary)
+	}
+
+	// The list depends on the viewer once signed in
+	policy := "public, " + relatedPolicy
+	if viewerId != "" {
+		policy = "private, " + relatedPolicy
+	}
+	c.Header("Vary", "Authorization")
+	cachedJSON(c, policy, articleListResponse{len(articles), articles})
+}
+
+// Loads the best related articles of a visible article, filled up with recent popular articles of its first tag
+// Returns sql.ErrNoRows if the article is not visible
+func (s *Server) loadRelatedArticles(ctx context.Context, id int) ([]relatedArticle, error) {
+	var tags string
+	q := `SELECT tags FROM articles WHERE id=$1 AND ` + visibleArticleSql
+	err := s.store.QueryRowContext(ctx, q, id).Scan(&tags)
+	if err != nil {
+		return nil, err
+	}
+
+	// Candidates share words of the title or hearting users, shared tags only add to their score
+	q = `WITH current AS (
+		SELECT string_to_array(tags, ',') AS tags,
+		NULLIF(replace(plainto_tsquery(title)::text, ' & ', ' | '), '')::tsquery AS query
+		FROM articles WHERE id = $1
+	), similar AS (
+		SELECT articles.id, ts_rank(articles.vector, current.query) AS rank
+		FROM articles, current
+		WHERE current.query IS NOT NULL AND articles.vector @@ current.query
+		ORDER BY rank DESC LIMIT 200
+	), cohearted AS (
+		SELECT other.articleId AS id, COUNT(*) AS users
+		FROM hearts mine JOIN hearts other ON other.userId = mine.userId AND other.articleId <> mine.articleId
+		WHERE mine.articleId = $1
+		GROUP BY other.articleId ORDER BY users DESC LIMIT 200
+	), candidates AS (
+		SELECT id, SUM(rank) AS rank, SUM(users) AS users FROM (
+			SELECT id, rank, 0 AS users FROM similar
+			UNION ALL SELECT id, 0, users FROM cohearted
+		) signals GROUP BY id
+	)
+	SELECT articles.id, articles.author, articles.author_google_id, articles.image_url, articles.title, articles.tags, articles.views, articles.hearts, articles.created
+	FROM candidates JOIN articles ON articles.id = candidates.id, current
+	WHERE articles.id <> $1 AND ` + visibleArticleSql + `
+	ORDER BY $2::float8 * candidates.rank + $3::float8 * LN(1 + candidates.users)
+		+ $4::float8 * cardinality(ARRAY(SELECT unnest(string_to_array(articles.tags, ',')) INTERSECT SELECT unnest(current.tags))) DESC,
+		articles.id DESC
+	LIMIT $5`
+	articles, err := s.queryRelatedArticles(ctx, nil, q, id, relatedTextWeight, relatedHeartWeight, relatedTagWeight, relatedCandidates)
+	if err != nil || len(articles) == relatedCandidates {
+		return articles, err
+	}
+
+	// Fill up with the most popular recent articles of the same tag (deterministic, ties broken by id)
+	q = `SELECT id, author, author_google_id, image_url, title, tags, views, hearts, created FROM articles
+	WHERE id <> $1 AND $2 = ANY(string_to_array(tags, ',')) AND created >= $3 AND ` + visibleArticleSql + `
+	ORDER BY hearts DESC, views DESC, id DESC LIMIT $4`
+	firstTag := strings.Split(tags, ",")[0]
+	since := s.clock.Now().Add(-relatedRecentDays * 24 * time.Hour)
+	return s.queryRelatedArticles(ctx, articles, q, id, firstTag, since, relatedCandidates)
+}
+
+// Appends the articles selected by q to articles, skipping those already in it, up to relatedCandidates
+func (s *Server) queryRelatedArticles(ctx context.Context, articles []relatedArticle, q string, args ...interface{}) ([]relatedArticle, error) {
+	seen := map[int]bool{}
+	for _, article := range articles {
+		seen[article.Id] = true
+	}
+	if articles == nil {
+		articles = []relatedArticle{}
+	}
+
+	rows, err := s.store.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() && len(articles) < relatedCandidates {
+		var article relatedArticle
+		var tags string
+		err = rows.Scan(&article.Id, &article.Author, &article.AuthorGoogleId, &article.ImageUrl, &article.Title, &tags, &article.Views, &article.Hearts, &article.Created)
+		if err != nil {
+			return nil, err
+		}
+		if seen[article.Id] {
+			continue
+		}
+		article.Tags = strings.Split(tags, ",")
+		articles = append(articles, article)
+	}
+	return articles, rows.Err()
+}
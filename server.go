@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+// Source of the current time, replaceable in tests
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// The api along with everything it depends on
+type Server struct {
+	config      *Config
+	store       Store
+	blobs       BlobStore
+	identity    IdentityProvider
+	captcha     CaptchaVerifier
+	clock       Clock
+	logger      *slog.Logger
+	rateLimiter rateLimitStore
+	views       *viewCounter
+	cache       Cache
+	loads       singleflight.Group // loads of cache misses in progress, by key
+	router      *gin.Engine
+	openAPI     []byte // built by the first request for it
+	openAPIErr  error
+	openAPIOnce sync.Once
+
+	stopJobs context.CancelFunc
+	jobsCtx  context.Context
+	jobs     sync.WaitGroup
+}
+
+func NewServer(config *Config, store Store, blobs BlobStore, identity IdentityProvider, captcha CaptchaVerifier, clock Clock, logger *slog.Logger) *Server {
+	s := &Server{
+		config:   config,
+		store:    store,
+		blobs:    blobs,
+		identity: identity,
+		captcha:  captcha,
+		clock:    clock,
+		logger:   logger,
+		views:    newViewCounter(),
+		cache:    newCache(config, clock),
+	}
+	s.jobsCtx, s.stopJobs = context.WithCancel(context.Background())
+	if config.RateLimitStore == "postgres" {
+		s.rateLimiter = newPostgresRateLimitStore(store)
+	} else {
+		s.rateLimiter = newMemoryRateLimitStore(clock)
+	}
+	s.router = s.handleRouting()
+	return s
+}
+
+// Handler serving the api, for use with any http.Server or httptest.Server
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// Starts the background jobs of the server
+func (s *Server) Start() {
+	s.runJob("purge deleted articles", s.config.PurgeInterval, s.purgeJob)
+	s.runJob("rank articles", s.config.RankingInterval, s.rankJob)
+	s.runJob("remember user ids", s.config.PurgeInterval, s.rememberUserIdsJob)
+	if s.config.RecommendationInterval > 0 {
+		s.runJob("recommend articles", s.config.RecommendationInterval, s.recommendJob)
+	}
+	s.runJob("flush view counts", s.config.ViewFlushInterval, func(ctx context.Context) error {
+		return s.views.Flush(ctx, s.store)
+	})
+	if sweeper, ok := s.rateLimiter.(*postgresRateLimitStore); ok {
+		s.runJob("sweep rate limits", rateLimitSweep, sweeper.Sweep)
+	}
+}
+
+// Runs job every interval until the server is closed, which also cancels a running job
+func (s *Server) runJob(name string, interval time.Duration, job func(ctx context.Context) error) {
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			err := job(s.jobsCtx)
+			if err != nil {
+				s.logger.Warn("background job failed", "job", name, "error", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-s.jobsCtx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stops the background jobs, flushes buffered view counts and closes the store
+// Call after the http server has stopped serving requests
+func (s *Server) Close() error {
+	s.stopJobs()
+	s.jobs.Wait()
+	err := s.views.Flush(context.Background(), s.store)
+	if err != nil {
+		s.logger.Warn("failed to flush view counts", "error", err)
+	}
+	err = s.cache.Close()
+	if err != nil {
+		s.logger.Warn("failed to close cache", "error", err)
+	}
+	return s.store.Close()
+}
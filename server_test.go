@@ -0,0 +1,853 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testArticleColumns = []string{"id", "author", "author_google_id", "image_url", "title", "body", "tags", "views", "hearts", "created", "updated", "latitude", "longitude", "place_name"}
+
+func (s *testServer) scriptArticle(id int64, updated time.Time) {
+	s.db.OnQuery(`FROM articles WHERE id=$1 AND`, testArticleColumns, []driver.Value{
+		id, "Author", "author google id", "https://api.crowdreport.me/images/a.png", "A title of the article", "Body",
+		"science,local", int64(3), int64(2), updated.Add(-time.Hour), updated, nil, nil, nil,
+	})
+}
+
+func decode(t *testing.T, response *http.Response, v interface{}) {
+	t.Helper()
+	err := json.NewDecoder(response.Body).Decode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFetchArticle(t *testing.T) {
+	s := newTestServer(t)
+	s.scriptArticle(1, s.clock.Now())
+
+	response := s.do(t, "GET", "/v1/articles/1", "", "", false)
+	if response.StatusCode != 200 {
+		t.Fatalf("status %d, want 200", response.StatusCode)
+	}
+	var article articleResponse
+	decode(t, response, &article)
+	if article.Id != 1 || article.Title != "A title of the article" || len(article.Tags) != 2 || article.Hearts != 2 {
+		t.Errorf("unexpected article %+v", article)
+	}
+	if article.AuthorGoogleId == "author google id" {
+		t.Error("google id of the author is not salted")
+	}
+	if article.Bookmark != nil {
+		t.Error("anonymous request got a bookmark")
+	}
+	etag := response.Header.Get("ETag")
+	if etag == "" || response.Header.Get("Cache-Control") != articlePolicy || response.Header.Get("Last-Modified") != "" {
+		t.Fatalf("unexpected caching headers %v", response.Header)
+	}
+
+	response = s.do(t, "GET", "/v1/articles/1", "", "", false, "If-None-Match", etag)
+	if response.StatusCode != 304 {
+		t.Errorf("revalidation status %d, want 304", response.StatusCode)
+	}
+
+	// The second request is served by the cache, both count as views
+	if n := len(s.db.Statements(`FROM articles WHERE id=$1 AND`)); n != 1 {
+		t.Errorf("article loaded %d times, want 1", n)
+	}
+	err := s.views.Flush(context.Background(), s.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flushed := s.db.Statements(`UPDATE articles SET views`)
+	if len(flushed) != 1 || flushed[0].args[1] != 2 {
+		t.Errorf("flushed views %v, want 2 views of article 1", flushed)
+	}
+}
+
+func TestFetchArticleErrors(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/v1/articles/2", 404},
+		{"/v1/articles/abc", 400},
+		{"/v1/articles/-1", 400},
+	}
+	for _, test := range tests {
+		response := s.do(t, "GET", test.path, "", "", false)
+		if response.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.path, response.StatusCode, test.status)
+		}
+	}
+}
+
+func TestFetchArticleSignedIn(t *testing.T) {
+	s := newTestServer(t)
+	s.scriptArticle(1, s.clock.Now())
+	s.db.OnQuery(`FROM bookmarks WHERE`, []string{"folder"}, []driver.Value{"reading"})
+
+	response := s.do(t, "GET", "/v1/articles/1", "", "", true)
+	if response.StatusCode != 200 {
+		t.Fatalf("status %d, want 200", response.StatusCode)
+	}
+	var article articleResponse
+	decode(t, response, &article)
+	if article.Bookmark == nil || !article.Bookmark.Bookmarked || article.Bookmark.Folder != "reading" {
+		t.Errorf("bookmark %+v, want bookmarked in reading", article.Bookmark)
+	}
+	if response.Header.Get("Cache-Control") != userArticlePolicy || response.Header.Get("Last-Modified") != "" {
+		t.Errorf("signed in copy is shared cacheable: %v", response.Header)
+	}
+
+	// Bad tokens and userinfo outages leave the public route anonymous
+	for _, token := range []string{"Bearer unknown", "Bearer " + testUnavailableToken, "Basic nonsense"} {
+		response = s.do(t, "GET", "/v1/articles/1", "", "", false, "Authorization", token)
+		if response.StatusCode != 200 {
+			t.Fatalf("%s: status %d, want 200", token, response.StatusCode)
+		}
+		var article articleResponse
+		decode(t, response, &article)
+		if article.Bookmark != nil || response.Header.Get("Cache-Control") != articlePolicy {
+			t.Errorf("%s: served %+v with %v, want the anonymous copy", token, article.Bookmark, response.Header)
+		}
+	}
+}
+
+func testArticleRequest(captcha string) string {
+	request, _ := json.Marshal(createArticleRequest{
+		ImageUrl: "https://api.crowdreport.me/images/cover.png",
+		Title:    "Bridge closed for repairs downtown",
+		Body:     strings.Repeat("The old river bridge closes tonight for repairs that should take about two weeks. ", 5),
+		Tags:     "science",
+		Captcha:  captcha,
+	})
+	return string(request)
+}
+
+func (s *testServer) scriptCreate() {
+	s.db.OnQuery(`FROM tags WHERE tag=$1`, []string{"exists"}, []driver.Value{true})
+	s.db.OnQuery(`SELECT MIN(created)`, []string{"min", "count"}, []driver.Value{nil, int64(0)})
+	s.db.OnQuery(`INSERT INTO articles`, []string{"id"}, []driver.Value{int64(7)})
+}
+
+func TestCreateArticle(t *testing.T) {
+	s := newTestServer(t)
+	s.scriptCreate()
+
+	response := s.do(t, "POST", "/v1/create", "application/json", testArticleRequest("ok"), true)
+	if response.StatusCode != 201 {
+		body, _ := io.ReadAll(response.Body)
+		t.Fatalf("status %d, want 201: %s", response.StatusCode, body)
+	}
+	var created createArticleResponse
+	decode(t, response, &created)
+	if created.Id != 7 || created.Held {
+		t.Errorf("created %+v, want published article 7", created)
+	}
+
+	inserts := s.db.Statements(`INSERT INTO articles`)
+	if len(inserts) != 1 {
+		t.Fatalf("%d inserts, want 1", len(inserts))
+	}
+	if inserts[0].args[0] != testUser.Name || inserts[0].args[1] != testUser.Id || inserts[0].args[9] != false {
+		t.Errorf("inserted %v, want a visible article by the test user", inserts[0].args)
+	}
+	remembered := s.db.Statements(`INSERT INTO user_ids`)
+	if len(remembered) != 1 || remembered[0].args[0] != s.saltedUserId(testUser.Id) || remembered[0].args[1] != testUser.Id {
+		t.Errorf("remembered user ids %v, want the salted id of the test user", remembered)
+	}
+	if len(s.db.Statements(`COMMIT`)) != 1 || len(s.db.Statements(`INSERT INTO reports`)) != 0 {
+		t.Error("article was not committed without a report")
+	}
+}
+
+func TestCreateArticleRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		signedIn bool
+		body     string
+		status   int
+	}{
+		{"signed out", false, testArticleRequest("ok"), 401},
+		{"rejected captcha", true, testArticleRequest("fail"), 401},
+		{"invalid fields", true, `{"title": "short"}`, 422},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.scriptCreate()
+
+			response := s.do(t, "POST", "/v1/create", "application/json", test.body, test.signedIn)
+			if response.StatusCode != test.status {
+				t.Errorf("status %d, want %d", response.StatusCode, test.status)
+			}
+			if len(s.db.Statements(`INSERT INTO articles`)) != 0 {
+				t.Error("rejected article was inserted")
+			}
+		})
+	}
+}
+
+func TestUserData(t *testing.T) {
+	s := newTestServer(t)
+
+	response := s.do(t, "GET", "/v1/userData", "", "", false)
+	if response.StatusCode != 401 {
+		t.Errorf("signed out status %d, want 401", response.StatusCode)
+	}
+
+	response = s.do(t, "GET", "/v1/userData", "", "", true)
+	if response.StatusCode != 200 {
+		t.Fatalf("status %d, want 200", response.StatusCode)
+	}
+	body, _ := io.ReadAll(response.Body)
+	if !strings.Contains(string(body), testUser.Name) {
+		t.Errorf("user data %s does not name the test user", body)
+	}
+}
+
+func TestFetchImage(t *testing.T) {
+	s := newTestServer(t)
+	s.blobs.Put(context.Background(), "cover.png", strings.NewReader("png bytes"))
+
+	response := s.do(t, "GET", "/v1/images/cover.png", "", "", false)
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != 200 || string(body) != "png bytes" {
+		t.Errorf("status %d body %q, want the stored image", response.StatusCode, body)
+	}
+}
+
+func TestHeart(t *testing.T) {
+	tests := []struct {
+		name    string
+		deleted int64
+		added   int64
+		hearted bool
+		change  interface{} // nil if the counter is not updated
+	}{
+		{"heart", 0, 1, true, int64(1)},
+		{"unheart", 1, 0, false, int64(-1)},
+		{"hearted concurrently", 0, 0, true, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.db.OnExec(`DELETE FROM hearts`, test.deleted)
+			s.db.OnExec(`INSERT INTO hearts`, test.added)
+
+			response := s.do(t, "POST", "/v1/heart", "application/json", `{"articleId": 3}`, true)
+			if response.StatusCode != 200 {
+				t.Fatalf("status %d, want 200", response.StatusCode)
+			}
+			var hearted heartedResponse
+			decode(t, response, &hearted)
+			if hearted.Hearted != test.hearted {
+				t.Errorf("hearted %v, want %v", hearted.Hearted, test.hearted)
+			}
+
+			updates := s.db.Statements(`UPDATE articles SET hearts`)
+			if test.change == nil && len(updates) != 0 {
+				t.Errorf("counter updated by %v, want no update", updates)
+			}
+			if test.change != nil && (len(updates) != 1 || updates[0].args[1] != test.change) {
+				t.Errorf("counter updates %v, want one by %v", updates, test.change)
+			}
+			if len(s.db.Statements(`COMMIT`)) != 1 {
+				t.Error("heart was not committed")
+			}
+		})
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	s := newTestServer(t)
+	s.db.OnError(`FROM schema_migrations`, errors.New(`relation "schema_migrations" does not exist`))
+
+	response := s.do(t, "GET", "/readyz", "", "", false)
+	if response.StatusCode != 503 {
+		t.Errorf("status %d, want 503", response.StatusCode)
+	}
+	body, _ := io.ReadAll(response.Body)
+	var readiness readinessResponse
+	err := json.Unmarshal(body, &readiness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readiness.Checks["migrations"].Status != "unavailable" || readiness.Checks["database"].Status != "ok" {
+		t.Errorf("checks %+v, want only the migrations unavailable", readiness.Checks)
+	}
+	if strings.Contains(string(body), "schema_migrations") {
+		t.Errorf("readiness %s exposes the error of a check", body)
+	}
+}
+
+func TestHeartKeepsListingsCached(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	listings := s.generation(ctx, listingsGeneration)
+	article := s.generation(ctx, articleGeneration(3))
+
+	response := s.do(t, "POST", "/v1/heart", "application/json", `{"articleId": 3}`, true)
+	if response.StatusCode != 200 {
+		t.Fatalf("status %d, want 200", response.StatusCode)
+	}
+	if s.generation(ctx, articleGeneration(3)) == article {
+		t.Error("hearted article is still cached")
+	}
+	if s.generation(ctx, listingsGeneration) != listings {
+		t.Error("hearting an article invalidated the listings")
+	}
+}
+
+func TestLegacyRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.db.OnQuery(`SELECT author_google_id FROM articles WHERE id=$1 AND deleted_at IS NULL`, []string{"author_google_id"}, []driver.Value{testUser.Id})
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"DELETE", "/v1/articles/5", 200, `{"id":5}`},
+		{"DELETE", "/articles/5", 200, `{"id":"5"}`},
+		{"POST", "/v1/create", 422, ""},
+		{"POST", "/create", 400, ""},
+		{"GET", "/v1/bookmarks", 200, ""},
+		{"GET", "/bookmarks", 404, ""},
+		{"GET", "/recommended", 404, ""},
+		{"GET", "/map", 404, ""},
+		{"GET", "/openapi.json", 404, ""},
+	}
+	for _, test := range tests {
+		response := s.do(t, test.method, test.path, "", "", true)
+		if response.StatusCode != test.status {
+			t.Errorf("%s %s: status %d, want %d", test.method, test.path, response.StatusCode, test.status)
+			continue
+		}
+		body, _ := io.ReadAll(response.Body)
+		if test.body != "" && string(body) != test.body {
+			t.Errorf("%s %s: body %s, want %s", test.method, test.path, body, test.body)
+		}
+		deprecated := !strings.HasPrefix(test.path, apiPrefix) && test.status != 404
+		if deprecated != (response.Header.Get("Deprecation") == "true") {
+			t.Errorf("%s %s: Deprecation header %q", test.method, test.path, response.Header.Get("Deprecation"))
+		}
+	}
+}
+
+func TestLegacyCreateInvalidArticle(t *testing.T) {
+	s := newTestServer(t)
+	s.scriptCreate()
+
+	response := s.do(t, "POST", "/create", "application/json", `{"title": "short"}`, true)
+	if response.StatusCode != 400 {
+		t.Fatalf("status %d, want 400", response.StatusCode)
+	}
+	var body errorBody
+	decode(t, response, &body)
+	if body.Name != invalidArticle.name || len(body.Fields) != 0 {
+		t.Errorf("error %+v, want invalidArticle without fields", body)
+	}
+	if len(s.db.Statements(`INSERT INTO articles`)) != 0 {
+		t.Error("invalid article was inserted")
+	}
+}
+
+func TestReplaceArticle(t *testing.T) {
+	var request createArticleRequest
+	json.Unmarshal([]byte(testArticleRequest("ok")), &request)
+	request.ReplaceId = 4
+	body, _ := json.Marshal(request)
+
+	tests := []struct {
+		name   string
+		owned  bool
+		status int
+	}{
+		{"own article", true, 201},
+		{"article of another user or deleted", false, 404},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.scriptCreate()
+			if test.owned {
+				s.db.OnQuery(`UPDATE articles SET author`, []string{"id"}, []driver.Value{int64(4)})
+			}
+
+			response := s.do(t, "POST", "/v1/create", "application/json", string(body), true)
+			if response.StatusCode != test.status {
+				t.Fatalf("status %d, want %d", response.StatusCode, test.status)
+			}
+			updates := s.db.Statements(`UPDATE articles SET author`)
+			if len(updates) != 1 || !strings.Contains(updates[0].query, "author_google_id = $2 AND deleted_at IS NULL") || updates[0].args[1] != testUser.Id {
+				t.Errorf("replaced with %v, want only an undeleted article of the test user", updates)
+			}
+			if committed := len(s.db.Statements(`COMMIT`)) == 1; committed != test.owned {
+				t.Errorf("committed %v, want %v", committed, test.owned)
+			}
+		})
+	}
+}
+
+func TestRestoreArticle(t *testing.T) {
+	tests := []struct {
+		name      string
+		deletedBy string
+		deletedAt time.Duration // ago
+		moderator bool
+		status    int
+		logged    bool
+	}{
+		{"deleted by the author", testUser.Email, time.Hour, false, 200, false},
+		{"taken down by a moderator", testModerator.Email, time.Hour, false, 403, false},
+		{"taken down and restored by a moderator", testModerator.Email, time.Hour, true, 200, true},
+		{"deleted by the author and restored by a moderator", testUser.Email, time.Hour, true, 200, true},
+		{"last day of the restore window", testUser.Email, 30*24*time.Hour - time.Minute, false, 200, false},
+		{"restore window expired", testUser.Email, 30*24*time.Hour + time.Minute, false, 410, false},
+		{"restore window expired for moderators too", testModerator.Email, 31 * 24 * time.Hour, true, 410, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.db.OnQuery(`SELECT author_google_id, deleted_at, deleted_by FROM articles`, []string{"author_google_id", "deleted_at", "deleted_by"},
+				[]driver.Value{testUser.Id, s.clock.Now().Add(-test.deletedAt), test.deletedBy})
+
+			var headers []string
+			if test.moderator {
+				headers = asModerator
+			}
+			response := s.do(t, "POST", "/v1/articles/4/restore", "", "", !test.moderator, headers...)
+			if response.StatusCode != test.status {
+				t.Fatalf("status %d, want %d", response.StatusCode, test.status)
+			}
+			if restored := len(s.db.Statements(`SET deleted_at = NULL`)) == 1; restored != (test.status == 200) {
+				t.Errorf("restored %v", restored)
+			}
+			if logged := len(s.db.Statements(`INSERT INTO moderation_log`)) == 1; logged != test.logged {
+				t.Errorf("logged %v, want %v", logged, test.logged)
+			}
+		})
+	}
+}
+
+func TestDeleteUnusedImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		used    bool
+		deleted bool
+	}{
+		{"unused", "https://api.crowdreport.me/images/a.png", false, true},
+		{"used by another article", "https://api.crowdreport.me/images/a.png", true, false},
+		{"outside the image store", "https://example.com/a.png", false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.blobs.Put(context.Background(), "a.png", strings.NewReader("png bytes"))
+			s.db.OnQuery(`SELECT exists(SELECT 1 FROM articles WHERE image_url=$1`, []string{"exists"}, []driver.Value{test.used})
+
+			err := s.deleteUnusedImage(context.Background(), test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = s.blobs.Get(context.Background(), "a.png")
+			if deleted := err != nil; deleted != test.deleted {
+				t.Errorf("deleted %v, want %v", deleted, test.deleted)
+			}
+			for _, check := range s.db.Statements(`SELECT exists(SELECT 1 FROM articles WHERE image_url=$1`) {
+				if !strings.Contains(check.query, "strpos(body, $2)") || check.args[1] != `src="`+test.url+`"` {
+					t.Errorf("usage check %v ignores inline images", check)
+				}
+			}
+		})
+	}
+}
+
+func TestReportArticleHides(t *testing.T) {
+	tests := []struct {
+		name    string
+		reports int64
+		hidden  int64 // rows the hiding update affects, 0 once the article is hidden
+		hides   bool
+		logged  bool
+	}{
+		{"below threshold", 4, 1, false, false},
+		{"threshold reached", 5, 1, true, true},
+		{"already hidden", 6, 0, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.db.OnQuery(`SELECT exists(SELECT 1 FROM articles WHERE id=$1`, []string{"exists"}, []driver.Value{true})
+			s.db.OnQuery(`SELECT COUNT(DISTINCT reporter_id)`, []string{"count"}, []driver.Value{test.reports})
+			s.db.OnExec(`UPDATE articles SET hidden = TRUE WHERE id=$1 AND NOT hidden`, test.hidden)
+
+			response := s.do(t, "POST", "/v1/articles/1/reports", "application/x-www-form-urlencoded", "reason=spam", true)
+			if response.StatusCode != 201 {
+				t.Fatalf("status %d, want 201", response.StatusCode)
+			}
+			if hides := len(s.db.Statements(`UPDATE articles SET hidden = TRUE`)) == 1; hides != test.hides {
+				t.Errorf("hid %v, want %v", hides, test.hides)
+			}
+			if logged := len(s.db.Statements(`INSERT INTO moderation_log`)) == 1; logged != test.logged {
+				t.Errorf("logged %v, want %v", logged, test.logged)
+			}
+			if len(s.db.Statements(`BEGIN`)) != 1 || len(s.db.Statements(`COMMIT`)) != 1 {
+				t.Error("report and hiding were not one transaction")
+			}
+		})
+	}
+}
+
+func TestModerationQueue(t *testing.T) {
+	s := newTestServer(t)
+	s.db.OnQuery(`FROM reports JOIN articles ON articles.id = reports.article_id
+	WHERE reports.status=$1`, []string{"id", "article_id", "title", "hidden", "reason", "details", "status", "created"},
+		[]driver.Value{int64(9), int64(4), "A title of the article", true, "spam", "", "dismissed", s.clock.Now()})
+
+	response := s.do(t, "GET", "/v1/moderation/reports", "", "", true)
+	if response.StatusCode != 403 {
+		t.Errorf("status %d for a user, want 403", response.StatusCode)
+	}
+
+	response = s.do(t, "GET", "/v1/moderation/reports?status=dismissed", "", "", false, asModerator...)
+	if response.StatusCode != 200 {
+		t.Fatalf("status %d, want 200", response.StatusCode)
+	}
+	var queue reportsResponse
+	decode(t, response, &queue)
+	if queue.Count != 1 || queue.Reports[0].Id != 9 || queue.Reports[0].Status != "dismissed" {
+		t.Errorf("queue %+v, want the dismissed report", queue)
+	}
+	if queries := s.db.Statements(`WHERE reports.status=$1`); len(queries) != 1 || queries[0].args[0] != "dismissed" {
+		t.Errorf("queries %v, want one for dismissed reports", queries)
+	}
+}
+
+func TestResolveReport(t *testing.T) {
+	tests := []struct {
+		action      string
+		statement   string // changing the article, empty if it is left as it is
+		status      string // of the closed reports
+		closes      string // which reports are closed
+		invalidates bool
+	}{
+		{"dismiss", "", "dismissed", "WHERE id=$2", false},
+		{"publish", "UPDATE articles SET hidden = FALSE", "dismissed", "WHERE article_id=$2", true},
+		{"hide", "UPDATE articles SET hidden = TRUE", "resolved", "WHERE article_id=$2", true},
+		{"warn", "INSERT INTO warnings", "resolved", "WHERE article_id=$2", false},
+		{"delete", "SET deleted_at = NOW(), deleted_by = $2", "resolved", "WHERE article_id=$2", true},
+	}
+	for _, test := range tests {
+		t.Run(test.action, func(t *testing.T) {
+			s := newTestServer(t)
+			s.db.OnQuery(`SELECT articles.id, articles.author_google_id FROM reports`, []string{"id", "author_google_id"},
+				[]driver.Value{int64(4), "author google id"})
+			cacheKey := s.articleCacheKey(context.Background(), 4)
+
+			response := s.do(t, "POST", "/v1/moderation/reports/9/resolve", "application/x-www-form-urlencoded",
+				"action="+test.action+"&note=Read+the+rules", false, asModerator...)
+			if response.StatusCode != 200 {
+				t.Fatalf("status %d, want 200", response.StatusCode)
+			}
+
+			if test.statement != "" {
+				changes := s.db.Statements(test.statement)
+				if len(changes) != 1 || changes[0].args[0] != 4 && changes[0].args[0] != "author google id" {
+					t.Errorf("changes %v, want one of article 4", changes)
+				}
+			}
+			if warnings := s.db.Statements(`INSERT INTO warnings`); len(warnings) == 1 && warnings[0].args[2] != "Read the rules" {
+				t.Errorf("warning %v without the note", warnings[0])
+			}
+			if deletes := s.db.Statements(`SET deleted_at = NOW()`); len(deletes) == 1 && deletes[0].args[1] != testModerator.Email {
+				t.Errorf("deleted by %v, want the moderator", deletes[0].args[1])
+			}
+			closed := s.db.Statements(`UPDATE reports SET status=$1`)
+			if len(closed) != 1 || closed[0].args[0] != test.status || !strings.Contains(closed[0].query, test.closes) {
+				t.Errorf("closed %v, want %s reports %s", closed, test.status, test.closes)
+			}
+			logged := s.db.Statements(`INSERT INTO moderation_log`)
+			if len(logged) != 1 || logged[0].args[0] != testModerator.Email || logged[0].args[3] != test.action {
+				t.Errorf("logged %v, want the %s by the moderator", logged, test.action)
+			}
+			if len(s.db.Statements(`COMMIT`)) != 1 {
+				t.Error("resolution was not committed")
+			}
+			if invalidated := s.articleCacheKey(context.Background(), 4) != cacheKey; invalidated != test.invalidates {
+				t.Errorf("invalidated %v, want %v", invalidated, test.invalidates)
+			}
+		})
+	}
+}
+
+func TestResolveReportRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		headers []string
+		status  int
+	}{
+		{"not a moderator", "/v1/moderation/reports/9/resolve", "action=hide", []string{}, 403},
+		{"unknown action", "/v1/moderation/reports/9/resolve", "action=ignore", asModerator, 400},
+		{"unknown report", "/v1/moderation/reports/10/resolve", "action=hide", asModerator, 404},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.db.OnQuery(`SELECT articles.id, articles.author_google_id FROM reports`, []string{"id", "author_google_id"})
+
+			response := s.do(t, "POST", test.path, "application/x-www-form-urlencoded", test.body, len(test.headers) == 0, test.headers...)
+			if response.StatusCode != test.status {
+				t.Errorf("status %d, want %d", response.StatusCode, test.status)
+			}
+			if len(s.db.Statements(`UPDATE reports`)) != 0 || len(s.db.Statements(`INSERT INTO moderation_log`)) != 0 {
+				t.Error("rejected resolution changed reports")
+			}
+		})
+	}
+}
+
+func TestPurgeDeletedArticles(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	for _, image := range []string{"cover.png", "gallery.png", "other.png"} {
+		s.blobs.Put(ctx, image, strings.NewReader("png bytes"))
+	}
+	s.db.OnQuery(`SELECT id FROM articles WHERE deleted_at < $1`, []string{"id"}, []driver.Value{int64(4)})
+	s.db.OnQuery(`SELECT image_url FROM articles WHERE id=$1`, []string{"image_url"},
+		[]driver.Value{"https://api.crowdreport.me/images/cover.png"}, []driver.Value{"https://api.crowdreport.me/images/gallery.png"})
+	s.db.OnQuery(`SELECT exists(SELECT 1 FROM articles WHERE image_url=$1`, []string{"exists"}, []driver.Value{false})
+
+	purged, err := s.purgeDeletedArticles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("purged %d, want 1", purged)
+	}
+	expired := s.db.Statements(`WHERE deleted_at < $1`)
+	if len(expired) != 1 || expired[0].args[0] != s.clock.Now().Add(-s.config.RetentionPeriod) {
+		t.Errorf("queries %v, want articles deleted before the retention period", expired)
+	}
+
+	// Everything referencing the article goes before it
+	deletes := s.db.Statements(`DELETE FROM`)
+	tables := []string{"hearts", "article_media", "reports", "recommendations", "bookmarks", "articles"}
+	if len(deletes) != len(tables) {
+		t.Fatalf("deletes %v, want one of each of %v", deletes, tables)
+	}
+	for i, table := range tables {
+		if !strings.HasPrefix(deletes[i].query, "DELETE FROM "+table+" ") || deletes[i].args[0] != 4 {
+			t.Errorf("delete %d is %v, want article 4 deleted from %s", i, deletes[i], table)
+		}
+	}
+	if len(s.db.Statements(`COMMIT`)) != 1 {
+		t.Error("purge was not committed")
+	}
+
+	for image, kept := range map[string]bool{"cover.png": false, "gallery.png": false, "other.png": true} {
+		if _, err := s.blobs.Get(ctx, image); (err == nil) != kept {
+			t.Errorf("%s kept %v, want %v", image, err == nil, kept)
+		}
+	}
+}
+
+func TestPurgeDeletedArticlesKeepsUsedImages(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	s.blobs.Put(ctx, "cover.png", strings.NewReader("png bytes"))
+	s.db.OnQuery(`SELECT id FROM articles WHERE deleted_at < $1`, []string{"id"}, []driver.Value{int64(4)})
+	s.db.OnQuery(`SELECT image_url FROM articles WHERE id=$1`, []string{"image_url"}, []driver.Value{"https://api.crowdreport.me/images/cover.png"})
+	s.db.OnQuery(`SELECT exists(SELECT 1 FROM articles WHERE image_url=$1`, []string{"exists"}, []driver.Value{true})
+
+	_, err := s.purgeDeletedArticles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.blobs.Get(ctx, "cover.png"); err != nil {
+		t.Error("image used by another article was deleted")
+	}
+}
+
+func TestPurgeDeletedArticlesFailure(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	s.blobs.Put(ctx, "cover.png", strings.NewReader("png bytes"))
+	s.db.OnQuery(`SELECT id FROM articles WHERE deleted_at < $1`, []string{"id"}, []driver.Value{int64(4)})
+	s.db.OnQuery(`SELECT image_url FROM articles WHERE id=$1`, []string{"image_url"}, []driver.Value{"https://api.crowdreport.me/images/cover.png"})
+	s.db.OnError(`DELETE FROM bookmarks`, errors.New("connection reset"))
+
+	purged, err := s.purgeDeletedArticles(ctx)
+	if err == nil || purged != 0 {
+		t.Fatalf("purged %d with error %v, want the failure", purged, err)
+	}
+	if len(s.db.Statements(`ROLLBACK`)) != 1 || len(s.db.Statements(`DELETE FROM articles`)) != 0 {
+		t.Error("failed purge was not rolled back")
+	}
+	if _, err := s.blobs.Get(ctx, "cover.png"); err != nil {
+		t.Error("image of an article that failed to purge was deleted")
+	}
+}
+
+func TestBan(t *testing.T) {
+	tests := []struct {
+		name    string
+		hours   string
+		expires interface{} // stored expiry, nil for permanent bans
+	}{
+		{"permanent", "0", nil},
+		{"suspension", "48", newFakeClock().Now().Add(48 * time.Hour)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			userId := s.saltedUserId(testUser.Id)
+			s.db.OnQuery(`SELECT google_id FROM user_ids WHERE user_id=$1`, []string{"google_id"}, []driver.Value{testUser.Id})
+			listings := s.generation(context.Background(), listingsGeneration)
+
+			body := "userId=" + userId + "&reason=Spam&hideArticles=true&hours=" + test.hours
+			response := s.do(t, "POST", "/v1/moderation/bans", "application/x-www-form-urlencoded", body, true)
+			if response.StatusCode != 403 {
+				t.Errorf("status %d for a user, want 403", response.StatusCode)
+			}
+			response = s.do(t, "POST", "/v1/moderation/bans", "application/x-www-form-urlencoded", body, false, asModerator...)
+			if response.StatusCode != 201 {
+				t.Fatalf("status %d, want 201", response.StatusCode)
+			}
+			var banned banResponse
+			decode(t, response, &banned)
+			if banned.UserId != userId || !banned.HideArticles || (banned.Expires == nil) != (test.expires == nil) {
+				t.Errorf("banned %+v", banned)
+			}
+
+			bans := s.db.Statements(`INSERT INTO bans`)
+			if len(bans) != 1 {
+				t.Fatalf("bans %v, want one", bans)
+			}
+			stored := bans[0].args[3].(sql.NullTime)
+			if stored.Valid != (test.expires != nil) || test.expires != nil && !stored.Time.Equal(test.expires.(time.Time)) {
+				t.Errorf("stored expiry %v, want %v", stored, test.expires)
+			}
+			if bans[0].args[1].(sql.NullString).String != testUser.Id || bans[0].args[5] != testModerator.Email {
+				t.Errorf("ban %v, want the resolved google id banned by the moderator", bans[0].args)
+			}
+			if s.generation(context.Background(), listingsGeneration) == listings {
+				t.Error("listings with articles of the banned user were not invalidated")
+			}
+		})
+	}
+}
+
+func TestBanRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"short user id", "userId=abc", 400},
+		{"long reason", "userId=" + strings.Repeat("a", 40) + "&reason=" + strings.Repeat("r", 501), 400},
+		{"negative hours", "userId=" + strings.Repeat("a", 40) + "&hours=-1", 400},
+		{"hours not a number", "userId=" + strings.Repeat("a", 40) + "&hours=week", 400},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			response := s.do(t, "POST", "/v1/moderation/bans", "application/x-www-form-urlencoded", test.body, false, asModerator...)
+			if response.StatusCode != test.status {
+				t.Errorf("status %d, want %d", response.StatusCode, test.status)
+			}
+			if len(s.db.Statements(`INSERT INTO bans`)) != 0 {
+				t.Error("rejected ban was stored")
+			}
+		})
+	}
+}
+
+func TestBanMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		ban    []driver.Value // active ban of the user, nil if none
+		status int
+		error  string
+	}{
+		{"not banned or suspension expired", nil, 200, ""},
+		{"banned", []driver.Value{nil}, 403, bannedUser.name},
+		{"suspended", []driver.Value{newFakeClock().Now().Add(time.Hour)}, 403, suspendedUser.name},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			if test.ban != nil {
+				s.db.OnQuery(`SELECT expires FROM bans WHERE user_id=$1`, []string{"expires"}, test.ban)
+			}
+
+			response := s.do(t, "POST", "/v1/heart", "application/json", `{"articleId": 3}`, true)
+			if response.StatusCode != test.status {
+				t.Fatalf("status %d, want %d", response.StatusCode, test.status)
+			}
+			if test.error != "" {
+				var body errorBody
+				decode(t, response, &body)
+				if body.Name != test.error || len(s.db.Statements(`INSERT INTO hearts`)) != 0 {
+					t.Errorf("error %q, want %q without hearting", body.Name, test.error)
+				}
+			}
+
+			// Expired suspensions are left to the database, only active bans are looked up
+			checks := s.db.Statements(`SELECT expires FROM bans`)
+			if len(checks) != 1 || checks[0].args[0] != s.saltedUserId(testUser.Id) || !strings.Contains(checks[0].query, "expires IS NULL OR expires > NOW()") {
+				t.Errorf("checks %v, want one for active bans of the salted user id", checks)
+			}
+		})
+	}
+}
+
+func TestUnban(t *testing.T) {
+	tests := []struct {
+		name   string
+		bans   int64 // rows the delete affects
+		status int
+	}{
+		{"banned user", 1, 200},
+		{"user without a ban", 0, 404},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.db.OnExec(`DELETE FROM bans`, test.bans)
+			userId := s.saltedUserId(testUser.Id)
+			listings := s.generation(context.Background(), listingsGeneration)
+
+			response := s.do(t, "DELETE", "/v1/moderation/bans/"+userId, "", "", true)
+			if response.StatusCode != 403 {
+				t.Errorf("status %d for a user, want 403", response.StatusCode)
+			}
+			response = s.do(t, "DELETE", "/v1/moderation/bans/"+userId, "", "", false, asModerator...)
+			if response.StatusCode != test.status {
+				t.Fatalf("status %d, want %d", response.StatusCode, test.status)
+			}
+			if deletes := s.db.Statements(`DELETE FROM bans`); len(deletes) != 1 || deletes[0].args[0] != userId {
+				t.Errorf("deletes %v, want the ban of the user", deletes)
+			}
+			if invalidated := s.generation(context.Background(), listingsGeneration) != listings; invalidated != (test.status == 200) {
+				t.Errorf("invalidated %v", invalidated)
+			}
+		})
+	}
+}
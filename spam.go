@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	shingleSize           = 4
+	maxLinks              = 5
+	maxLinksPerHundred    = 3
+	duplicateLookback     = 7 * 24 * time.Hour
+	authorRecentArticles  = 20
+	siteRecentArticles    = 100
+	newAccountAge         = 72 * time.Hour
+	newAccountDailyLimit  = 2
+	maxBannedWordLength   = 50
+	defaultBannedWordRisk = 1.0
+)
+
+var (
+	linkRgx = regexp.MustCompile(`https?://[^\s<>"]+`)
+	wordRgx = regexp.MustCompile(`[a-z0-9']+`)
+	htmlRgx = regexp.MustCompile(`<[^>]*>`)
+)
+
+// An article about to be created or replaced
+type articleDraft struct {
+	authorGoogleId string
+	replaceId      int
+	title          string
+	body           string
+	tags           string
+}
+
+// A content checker scores a draft (0 means clean) and explains why
+type contentChecker func(s *Server, ctx context.Context, draft articleDraft) (float64, string, error)
+
+// Content checkers run on every draft, in order
+var contentCheckers = []contentChecker{
+	(*Server).checkLinkDensity,
+	(*Server).checkDuplicates,
+	(*Server).checkBannedWords,
+	(*Server).checkNewAccountRate,
+}
+
+// Runs all content checkers and sums their scores
+func (s *Server) checkContent(ctx context.Context, draft articleDraft) (float64, []string, error) {
+	score := 0.0
+	reasons := []string{}
+	for _, checker := range contentCheckers {
+		checkScore, reason, err := checker(s, ctx, draft)
+		if err != nil {
+			return 0, nil, err
+		}
+		if checkScore > 0 {
+			score += checkScore
+			reasons = append(reasons, reason)
+		}
+	}
+	return score, reasons, nil
+}
+
+// Strips html tags from an article body
+func stripTags(body string) string {
+	return htmlRgx.ReplaceAllString(body, " ")
+}
+
+func words(text string) []string {
+	return wordRgx.FindAllString(strings.ToLower(text), -1)
+}
+
+// Penalises bodies that are mostly links to other sites
+func (s *Server) checkLinkDensity(ctx context.Context, draft articleDraft) (float64, string, error) {
+	links := 0
+	for _, link := range linkRgx.FindAllString(draft.body, -1) {
+		if !s.isStoreImageUrl(link) {
+			links++
+		}
+	}
+	wordCount := len(words(stripTags(draft.body)))
+	if links > maxLinks || links*100 > maxLinksPerHundred*wordCount {
+		return 1.5, fmt.Sprintf("%d links in %d words", links, wordCount), nil
+	}
+	return 0, "", nil
+}
+
+// Word shingles of a text used for near-duplicate detection
+func shingles(text string) map[string]bool {
+	set := map[string]bool{}
+	w := words(text)
+	for i := 0; i+shingleSize <= len(w); i++ {
+		set[strings.Join(w[i:i+shingleSize], " ")] = true
+	}
+	return set
+}
+
+// Jaccard similarity of two shingle sets
+func similarity(a map[string]bool, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for shingle := range a {
+		if b[shingle] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// Penalises reposts of the authors own recent articles and copies of other recent articles
+func (s *Server) checkDuplicates(ctx context.Context, draft articleDraft) (float64, string, error) {
+	q := `(SELECT author_google_id, body FROM articles WHERE author_google_id=$1 AND id<>$2 AND created >= $3 ORDER BY created DESC LIMIT $4)
+	UNION ALL
+	(SELECT author_google_id, body FROM articles WHERE author_google_id<>$1 AND id<>$2 AND created >= $3 ORDER BY created DESC LIMIT $5)`
+	rows, err := s.store.QueryContext(ctx, q, draft.authorGoogleId, draft.replaceId, s.clock.Now().Add(-duplicateLookback), authorRecentArticles, siteRecentArticles)
+	if err != nil {
+		return 0, "", err
+	}
+	defer rows.Close()
+
+	draftShingles := shingles(stripTags(draft.body))
+	score := 0.0
+	reason := ""
+	for rows.Next() {
+		var authorGoogleId string
+		var body string
+		err = rows.Scan(&authorGoogleId, &body)
+		if err != nil {
+			return 0, "", err
+		}
+		sim := similarity(draftShingles, shingles(stripTags(body)))
+		if authorGoogleId == draft.authorGoogleId && sim >= 0.9 && score < 1 {
+			score, reason = 1, fmt.Sprintf("%.0f%% similar to a recent article by the same author", sim*100)
+		} else if authorGoogleId != draft.authorGoogleId && sim >= 0.9 {
+			return 2.5, fmt.Sprintf("%.0f%% similar to a recent article by another author", sim*100), nil
+		} else if authorGoogleId != draft.authorGoogleId && sim >= 0.7 && score < 1 {
+			score, reason = 1, fmt.Sprintf("%.0f%% similar to a recent article by another author", sim*100)
+		}
+	}
+	return score, reason, rows.Err()
+}
+
+// Adds the risk of every banned word found in the draft
+func (s *Server) checkBannedWords(ctx context.Context, draft articleDraft) (float64, string, error) {
+	rows, err := s.store.QueryContext(ctx, `SELECT word, risk FROM banned_words`)
+	if err != nil {
+		return 0, "", err
+	}
+	defer rows.Close()
+
+	text := " " + strings.Join(words(draft.title+" "+stripTags(draft.body)+" "+draft.tags), " ") + " "
+	score := 0.0
+	found := []string{}
+	for rows.Next() {
+		var word string
+		var risk float64
+		err = rows.Scan(&word, &risk)
+		if err != nil {
+			return 0, "", err
+		}
+		if strings.Contains(text, " "+word+" ") {
+			score += risk
+			found = append(found, word)
+		}
+	}
+	return score, "banned words: " + strings.Join(found, ", "), rows.Err()
+}
+
+// Limits how many articles accounts without history can publish per day
+func (s *Server) checkNewAccountRate(ctx context.Context, draft articleDraft) (float64, string, error) {
+	if draft.replaceId > -1 {
+		return 0, "", nil
+	}
+	var first sql.NullTime
+	var today int
+	q := `SELECT MIN(created), COUNT(*) FILTER (WHERE created >= $2) FROM articles WHERE author_google_id=$1`
+	err := s.store.QueryRowContext(ctx, q, draft.authorGoogleId, s.clock.Now().Add(-24*time.Hour)).Scan(&first, &today)
+	if err != nil {
+		return 0, "", err
+	}
+	if first.Valid && s.clock.Now().Sub(first.Time) > newAccountAge {
+		return 0, "", nil
+	}
+	if today >= newAccountDailyLimit {
+		return s.config.SpamRejectScore, fmt.Sprintf("new account already published %d articles today", today), nil
+	}
+	return 0, "", nil
+}
+
+// Queues a held article for moderation, in the transaction writing it hidden
+func (s *Server) holdArticle(ctx context.Context, tx execer, articleId int, reasons []string) error {
+	q := `INSERT INTO reports (article_id, reporter_id, reason, details) VALUES ($1, 'system', 'spam', $2)
+	ON CONFLICT (article_id, reporter_id) DO UPDATE SET status = 'open', details = EXCLUDED.details, created = NOW(), resolved = NULL`
+	details := strings.Join(reasons, "; ")
+	if len(details) > maxReportDetailsLength {
+		details = details[:maxReportDetailsLength]
+	}
+	_, err := tx.ExecContext(ctx, q, articleId, details)
+	if err != nil {
+		return err
+	}
+	return logModeration(ctx, tx, "system", articleId, nil, "hold", details)
+}
+
+// Responds with all banned words
+func (s *Server) bannedWordsHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	rows, err := s.store.QueryContext(ctx, `SELECT word, risk FROM banned_words ORDER BY word`)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	bannedWords := []bannedWord{}
+	for rows.Next() {
+		var word string
+		var risk float64
+		err = rows.Scan(&word, &risk)
+		if err != nil {
+			panic(err)
+		}
+		bannedWords = append(bannedWords, bannedWord{word, risk})
+	}
+	err = rows.Err()
+	if err != nil {
+		panic(err)
+	}
+
+	c.JSON(200, bannedWordsResponse{len(bannedWords), bannedWords})
+}
+
+// Adds or updates a banned word
+func (s *Server) addBannedWordHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	word := strings.ToLower(strings.TrimSpace(c.DefaultPostForm("word", "")))
+	if len(words(word)) != 1 || words(word)[0] != word || len(word) > maxBannedWordLength {
+		panic(invalidBannedWord)
+	}
+	risk, err := strconv.ParseFloat(c.DefaultPostForm("risk", strconv.FormatFloat(defaultBannedWordRisk, 'f', -1, 64)), 64)
+	if err != nil || risk <= 0 || risk > s.config.SpamRejectScore {
+		panic(invalidNumber)
+	}
+
+	q := `INSERT INTO banned_words (word, risk) VALUES ($1, $2) ON CONFLICT (word) DO UPDATE SET risk = EXCLUDED.risk`
+	_, err = s.store.ExecContext(ctx, q, word, risk)
+	if err != nil {
+		panic(err)
+	}
+
+	c.JSON(201, bannedWord{word, risk})
+}
+
+// Removes a banned word
+func (s *Server) removeBannedWordHandler(c *gin.Context) {
+	defer handleError(c)
+	ctx := c.Request.Context()
+
+	word := c.Param("word")
+	result, err := s.store.ExecContext(ctx, `DELETE FROM banned_words WHERE word=$1`, word)
+	if err != nil {
+		panic(err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		panic(notFound)
+	}
+
+	c.JSON(200, removedWordResponse{word})
+}
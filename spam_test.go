@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Text of the words word<from> to word<to - 1>, every word unique
+func numberedWords(from int, to int) string {
+	w := []string{}
+	for i := from; i < to; i++ {
+		w = append(w, fmt.Sprintf("word%d", i))
+	}
+	return strings.Join(w, " ")
+}
+
+func TestStripTags(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{"<p>a <b>bold</b> move</p>", " a  bold  move "},
+		{`<img src="https://api.crowdreport.me/images/a.png">caption`, " caption"},
+		{"1 < 2 and 3 > 2", "1   2"},
+	}
+	for _, test := range tests {
+		if got := stripTags(test.body); got != test.want {
+			t.Errorf("stripTags(%q) = %q, want %q", test.body, got, test.want)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"Hello, World!", []string{"hello", "world"}},
+		{"It's 2 o'clock", []string{"it's", "2", "o'clock"}},
+		{"https://example.com/a", []string{"https", "example", "com", "a"}},
+		{"ünïcode", []string{"n", "code"}},
+	}
+	for _, test := range tests {
+		if got := words(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("words(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestShingles(t *testing.T) {
+	tests := []struct {
+		text string
+		want map[string]bool
+	}{
+		{"too few words", map[string]bool{}},
+		{"one two three four", map[string]bool{"one two three four": true}},
+		{"One two three four five", map[string]bool{"one two three four": true, "two three four five": true}},
+		{"a b c d a b c d", map[string]bool{"a b c d": true, "b c d a": true, "c d a b": true, "d a b c": true}},
+	}
+	for _, test := range tests {
+		if got := shingles(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("shingles(%q) = %v, want %v", test.text, got, test.want)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want float64
+	}{
+		{"identical", numberedWords(0, 10), numberedWords(0, 10), 1},
+		{"disjoint", numberedWords(0, 10), numberedWords(10, 20), 0},
+		{"empty", "", numberedWords(0, 10), 0},
+		{"both empty", "", "", 0},
+		// 7 shingles each, 4 shared: 4 / (7 + 7 - 4)
+		{"overlapping", numberedWords(0, 10), numberedWords(3, 13), 0.4},
+	}
+	for _, test := range tests {
+		if got := similarity(shingles(test.a), shingles(test.b)); got != test.want {
+			t.Errorf("%s: similarity %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckLinkDensity(t *testing.T) {
+	s := newTestServer(t)
+	links := func(count int, url string) string {
+		return strings.Repeat(" "+url, count)
+	}
+	// Every https://example.com/x adds 4 words (https, example, com and x)
+	tests := []struct {
+		name  string
+		body  string
+		score float64
+	}{
+		{"no links", numberedWords(0, 100), 0},
+		{"3 links in 112 words", numberedWords(0, 100) + links(3, "https://example.com/x"), 0},
+		{"4 links in 116 words", numberedWords(0, 100) + links(4, "https://example.com/x"), 1.5},
+		{"5 links in 1020 words", numberedWords(0, 1000) + links(5, "https://example.com/x"), 0},
+		{"6 links in 1024 words", numberedWords(0, 1000) + links(6, "https://example.com/x"), 1.5},
+		{"links inside tags", numberedWords(0, 100) + links(4, `<a href="https://example.com/x">x</a>`), 1.5},
+		{"images of the store", numberedWords(0, 10) + links(10, "https://api.crowdreport.me/images/a.png"), 0},
+	}
+	for _, test := range tests {
+		score, _, err := s.checkLinkDensity(context.Background(), articleDraft{body: test.body})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if score != test.score {
+			t.Errorf("%s: score %v, want %v", test.name, score, test.score)
+		}
+	}
+}
+
+func TestCheckBannedWords(t *testing.T) {
+	tests := []struct {
+		name  string
+		draft articleDraft
+		score float64
+	}{
+		{"clean", articleDraft{title: "Bridge closed", body: "<p>Repairs take two weeks</p>"}, 0},
+		{"in the title", articleDraft{title: "A scam", body: "text"}, 1},
+		{"any case and punctuation", articleDraft{title: "title", body: "What a SCAM!"}, 1},
+		{"inside html", articleDraft{title: "title", body: "<b>scam</b>"}, 1},
+		{"in the tags", articleDraft{title: "title", body: "text", tags: "local,cash"}, 0.5},
+		{"several words", articleDraft{title: "scam", body: "cash"}, 1.5},
+		{"part of a word", articleDraft{title: "Scammers", body: "cashier"}, 0},
+		{"html tag names", articleDraft{title: "title", body: "<scam>text</scam>"}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.db.OnQuery(`SELECT word, risk FROM banned_words`, []string{"word", "risk"},
+				[]driver.Value{"scam", 1.0}, []driver.Value{"cash", 0.5})
+
+			score, _, err := s.checkBannedWords(context.Background(), test.draft)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if score != test.score {
+				t.Errorf("score %v, want %v", score, test.score)
+			}
+		})
+	}
+}
+
+func TestCheckDuplicates(t *testing.T) {
+	// 37 shingles, a copy with its last 4 words replaced shares 33 of 41 (80%), one with its last 20 replaced 17 of 57 (30%)
+	draft := numberedWords(0, 40)
+	nearCopy := numberedWords(0, 36) + " " + numberedWords(100, 104)
+	distinct := numberedWords(0, 20) + " " + numberedWords(100, 120)
+
+	tests := []struct {
+		name   string
+		author string
+		body   string
+		score  float64
+	}{
+		{"own repost", testUser.Id, draft, 1},
+		{"own near copy", testUser.Id, nearCopy, 0},
+		{"copy of another author", "other", draft, 2.5},
+		{"near copy of another author", "other", nearCopy, 1},
+		{"distinct article of another author", "other", distinct, 0},
+		{"copy in html", "other", "<p>" + strings.ReplaceAll(draft, " ", " <br> ") + "</p>", 2.5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.db.OnQuery(`SELECT author_google_id, body FROM articles`, []string{"author_google_id", "body"},
+				[]driver.Value{test.author, test.body})
+
+			score, _, err := s.checkDuplicates(context.Background(), articleDraft{authorGoogleId: testUser.Id, replaceId: -1, body: draft})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if score != test.score {
+				t.Errorf("score %v, want %v", score, test.score)
+			}
+		})
+	}
+}
+
+func TestCheckDuplicatesKeepsWorstMatch(t *testing.T) {
+	draft := numberedWords(0, 40)
+	s := newTestServer(t)
+	s.db.OnQuery(`SELECT author_google_id, body FROM articles`, []string{"author_google_id", "body"},
+		[]driver.Value{testUser.Id, draft}, []driver.Value{"other", draft}, []driver.Value{"another", numberedWords(0, 36) + " x y z w"})
+
+	score, reason, err := s.checkDuplicates(context.Background(), articleDraft{authorGoogleId: testUser.Id, replaceId: -1, body: draft})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score != 2.5 || !strings.Contains(reason, "another author") {
+		t.Errorf("score %v (%s), want the copy of another author", score, reason)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "crowd-report-api"
+
+// Tracer of the api, a no-op until setupTracing installs an exporter
+var tracer = otel.Tracer(serviceName)
+
+// Installs the global tracer provider and w3c trace context propagation
+// Returns a function flushing buffered spans, to be called on shutdown
+func setupTracing(config *Config) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.TracingExporter {
+	case "none":
+		return func(ctx context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		// Endpoint, headers and protocol options are read from the standard OTEL_EXPORTER_OTLP_* env vars
+		exporter, err = otlptracehttp.New(context.Background())
+	default:
+		err = fmt.Errorf("unknown tracing exporter %q", config.TracingExporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewSchemaless(
+		attribute.String("service.name", serviceName),
+		attribute.String("service.version", buildCommit),
+	)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.TracingSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Marks a span as failed
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Http client creating a span for every outbound request and propagating the trace context
+func newTracedHttpClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: otelhttp.NewTransport(http.DefaultTransport, otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			return r.Method + " " + r.URL.Host + r.URL.Path
+		})),
+	}
+}
+
+// Middleware continuing the trace of the caller (if any) with a span per request, named by route template
+// Must come first so the request context carries the span for everything after it
+func tracingMiddleware(c *gin.Context) {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("http.route", route),
+			attribute.String("url.path", c.Request.URL.Path),
+			attribute.String("client.address", c.ClientIP()),
+		),
+	)
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+	if status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
+
+// Starts a span for a single sql statement
+func startQuerySpan(ctx context.Context, operation string, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "db."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation", operation),
+			attribute.String("db.statement", query),
+		),
+	)
+}
+
+// Blob store creating a span for every operation of another blob store
+type tracingBlobStore struct {
+	BlobStore
+}
+
+func startBlobSpan(ctx context.Context, operation string, key string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "s3."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("s3.key", key)),
+	)
+}
+
+func (b tracingBlobStore) Put(ctx context.Context, key string, body io.Reader) error {
+	ctx, span := startBlobSpan(ctx, "put", key)
+	err := b.BlobStore.Put(ctx, key, body)
+	endSpan(span, err)
+	return err
+}
+
+func (b tracingBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	ctx, span := startBlobSpan(ctx, "get", key)
+	data, err := b.BlobStore.Get(ctx, key)
+	span.SetAttributes(attribute.Int("s3.size", len(data)))
+	endSpan(span, err)
+	return data, err
+}
+
+func (b tracingBlobStore) Delete(ctx context.Context, key string) error {
+	ctx, span := startBlobSpan(ctx, "delete", key)
+	err := b.BlobStore.Delete(ctx, key)
+	endSpan(span, err)
+	return err
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+const maxFormMemory = 32 << 20
+
+// A field of a request that failed validation, named by its json (and form) name
+type fieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Panicked to respond 422 with every failing field
+type fieldErrors []fieldError
+
+// Reasons of the custom validation tags used in binding rules
+var validationReasons = map[string]string{
+	"title": "must be 15 to 75 characters, not starting or ending with a space",
+	"tags":  "must be 1 to 75 characters of comma separated tags",
+}
+
+func init() {
+	engine := binding.Validator.Engine().(*validator.Validate)
+	engine.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _ := jsonName(field)
+		return name
+	})
+	engine.RegisterValidation("title", matchValidation(titleRgx))
+	engine.RegisterValidation("tags", matchValidation(tagsRgx))
+}
+
+func matchValidation(rgx string) validator.Func {
+	compiled := regexp.MustCompile(rgx)
+	return func(fl validator.FieldLevel) bool {
+		return compiled.MatchString(fl.Field().String())
+	}
+}
+
+// Decodes a json or form body into req (a pointer to a request struct) and checks its binding rules
+// Panics with invalidBody if the body can not be read at all
+func bindRequest(c *gin.Context, req interface{}) fieldErrors {
+	var fields fieldErrors
+	if c.ContentType() == binding.MIMEJSON {
+		err := json.NewDecoder(c.Request.Body).Decode(req)
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			fields = append(fields, fieldError{typeErr.Field, "must be " + describeType(typeErr.Type)})
+		} else if err != nil && err != io.EOF {
+			// An empty body has no fields, required ones are reported below
+			// A body that is not an object (ex. an array) has no field to blame
+			panic(invalidBody)
+		}
+	} else {
+		err := c.Request.ParseMultipartForm(maxFormMemory)
+		if err != nil && err != http.ErrNotMultipart {
+			panic(invalidBody)
+		}
+		fields = append(fields, decodeForm(c.Request.PostForm, req)...)
+	}
+
+	// Fields that could not be decoded are reported once
+	undecoded := map[string]bool{}
+	for _, field := range fields {
+		undecoded[field.Field] = true
+	}
+	err := binding.Validator.ValidateStruct(req)
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		for _, fe := range validationErrs {
+			field := validationField(fe)
+			if !undecoded[field] {
+				fields = append(fields, fieldError{field, validationReason(fe)})
+			}
+		}
+	} else if err != nil {
+		panic(err)
+	}
+	return fields
+}
+
+// Decodes form values into the fields of req with the same json name
+// Non string fields are parsed as json (ex. numbers, booleans or the media array), empty values are left unset
+func decodeForm(form map[string][]string, req interface{}) fieldErrors {
+	var fields fieldErrors
+	v := reflect.ValueOf(req).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name, _ := jsonName(v.Type().Field(i))
+		values := form[name]
+		if name == "" || len(values) == 0 || values[0] == "" {
+			continue
+		}
+		target := v.Field(i)
+		if target.Kind() == reflect.String {
+			target.SetString(values[0])
+			continue
+		}
+		err := json.Unmarshal([]byte(values[0]), target.Addr().Interface())
+		if err != nil {
+			fields = append(fields, fieldError{name, "must be " + describeType(target.Type())})
+		}
+	}
+	return fields
+}
+
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return describeType(t.Elem())
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a json array"
+	case reflect.Struct, reflect.Map:
+		return "a json object"
+	}
+	return "a string"
+}
+
+// Path of a failing field without the request struct (ex. "media[0].url")
+func validationField(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+func validationReason(fe validator.FieldError) string {
+	if reason, ok := validationReasons[fe.Tag()]; ok {
+		return reason
+	}
+	unit := ""
+	switch fe.Kind() {
+	case reflect.String:
+		unit = " characters"
+	case reflect.Slice, reflect.Array:
+		unit = " items"
+	}
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "required_with":
+		return "is required along with " + strings.ToLower(fe.Param()[:1]) + fe.Param()[1:]
+	case "min":
+		return "must be at least " + fe.Param() + unit
+	case "max":
+		return "must be at most " + fe.Param() + unit
+	}
+	return fmt.Sprintf("failed the %s rule", fe.Tag())
+}
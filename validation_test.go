@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var validArticleFields = map[string]string{
+	"imageUrl": "https://api.crowdreport.me/images/cover.png",
+	"title":    "Bridge closed for repairs downtown",
+	"body":     strings.Repeat("a", 300),
+	"tags":     "science,local",
+}
+
+func multipartBody(t *testing.T, fields map[string]string) (string, string) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		err := writer.WriteField(name, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writer.FormDataContentType(), body.String()
+}
+
+// Binds a request body into a createArticleRequest, recovering the error response bindRequest panics with
+func bindTestRequest(contentType string, body string) (req createArticleRequest, fields fieldErrors, panicked interface{}) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/create", strings.NewReader(body))
+	if contentType != "" {
+		c.Request.Header.Set("Content-Type", contentType)
+	}
+	defer func() {
+		panicked = recover()
+	}()
+	fields = bindRequest(c, &req)
+	return
+}
+
+func TestBindRequest(t *testing.T) {
+	multipartType, multipartFields := multipartBody(t, map[string]string{
+		"imageUrl":  validArticleFields["imageUrl"],
+		"title":     validArticleFields["title"],
+		"body":      validArticleFields["body"],
+		"tags":      validArticleFields["tags"],
+		"latitude":  "45.5",
+		"longitude": "-122.6",
+		"media":     `[{"url": "https://api.crowdreport.me/images/a.png", "caption": "A"}]`,
+	})
+	multipartInvalidType, multipartInvalid := multipartBody(t, map[string]string{"latitude": "north"})
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		fields      fieldErrors
+		check       func(req createArticleRequest) bool
+	}{
+		{
+			name:        "json",
+			contentType: "application/json",
+			body:        `{"imageUrl": "https://api.crowdreport.me/images/cover.png", "title": "Bridge closed for repairs downtown", "body": "` + strings.Repeat("a", 300) + `", "tags": "science,local", "replaceId": 4}`,
+			check: func(req createArticleRequest) bool {
+				return req.Title == validArticleFields["title"] && req.ReplaceId == 4
+			},
+		},
+		{
+			name:        "urlencoded",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "imageUrl=https%3A%2F%2Fapi.crowdreport.me%2Fimages%2Fcover.png&title=Bridge+closed+for+repairs+downtown&tags=science&replaceId=3&body=" + strings.Repeat("a", 300),
+			check: func(req createArticleRequest) bool {
+				return req.ImageUrl == validArticleFields["imageUrl"] && req.Title == validArticleFields["title"] && req.ReplaceId == 3
+			},
+		},
+		{
+			name:        "multipart",
+			contentType: multipartType,
+			body:        multipartFields,
+			check: func(req createArticleRequest) bool {
+				return req.Latitude != nil && *req.Latitude == 45.5 && len(req.Media) == 1 && req.Media[0].Caption == "A"
+			},
+		},
+		{
+			name:        "empty json body",
+			contentType: "application/json",
+			body:        "",
+			fields: fieldErrors{
+				{"imageUrl", "is required"},
+				{"title", "is required"},
+				{"body", "is required"},
+				{"tags", "is required"},
+			},
+		},
+		{
+			name:        "empty form body",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "",
+			fields: fieldErrors{
+				{"imageUrl", "is required"},
+				{"title", "is required"},
+				{"body", "is required"},
+				{"tags", "is required"},
+			},
+		},
+		{
+			name:        "json type error",
+			contentType: "application/json",
+			body:        `{"imageUrl": "https://api.crowdreport.me/images/cover.png", "title": "Bridge closed for repairs downtown", "body": "` + strings.Repeat("a", 300) + `", "tags": "science", "replaceId": "four"}`,
+			fields:      fieldErrors{{"replaceId", "must be an integer"}},
+		},
+		{
+			name:        "form type error",
+			contentType: multipartInvalidType,
+			body:        multipartInvalid,
+			fields: fieldErrors{
+				{"latitude", "must be a number"},
+				{"imageUrl", "is required"},
+				{"title", "is required"},
+				{"body", "is required"},
+				{"tags", "is required"},
+			},
+		},
+		{
+			name:        "several failing fields",
+			contentType: "application/json",
+			body:        `{"imageUrl": "x", "title": "short", "body": "too short", "tags": "science", "latitude": 91, "placeName": "` + strings.Repeat("p", 76) + `", "media": [{"caption": "A"}, {"url": "x", "alt": "` + strings.Repeat("a", 151) + `"}]}`,
+			fields: fieldErrors{
+				{"title", validationReasons["title"]},
+				{"body", "must be at least 300 characters"},
+				{"media[0].url", "is required"},
+				{"media[1].alt", "must be at most 150 characters"},
+				{"latitude", "must be at most 90"},
+				{"longitude", "is required along with latitude"},
+				{"placeName", "must be at most 75 characters"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, fields, panicked := bindTestRequest(test.contentType, test.body)
+			if panicked != nil {
+				t.Fatalf("panicked with %v", panicked)
+			}
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("fields %v, want %v", fields, test.fields)
+			}
+			if test.check != nil && !test.check(req) {
+				t.Errorf("decoded %+v", req)
+			}
+		})
+	}
+}
+
+func TestBindRequestUnreadableBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"malformed json", "application/json", `{"title": `},
+		{"json array", "application/json", `[1, 2]`},
+		{"malformed multipart", "multipart/form-data; boundary=x", "not multipart"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, panicked := bindTestRequest(test.contentType, test.body)
+			if panicked != invalidBody {
+				t.Errorf("panicked with %v, want invalidBody", panicked)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Buffers article view increments in memory so fetching an article does not write to the database
+type viewCounter struct {
+	mutex  sync.Mutex
+	counts map[int]int
+}
+
+func newViewCounter() *viewCounter {
+	return &viewCounter{counts: map[int]int{}}
+}
+
+func (v *viewCounter) Add(articleId int) {
+	v.mutex.Lock()
+	v.counts[articleId]++
+	v.mutex.Unlock()
+}
+
+// Writes all buffered views to the store
+// Views that could not be written are kept for the next flush
+func (v *viewCounter) Flush(ctx context.Context, store Store) error {
+	v.mutex.Lock()
+	counts := v.counts
+	v.counts = map[int]int{}
+	v.mutex.Unlock()
+	if len(counts) == 0 {
+		return nil
+	}
+
+	tx, err := store.BeginTx(ctx, nil)
+	if err == nil {
+		for articleId, views := range counts {
+			_, err = tx.ExecContext(ctx, `UPDATE articles SET views = views + $2 WHERE id=$1`, articleId, views)
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+	if err != nil {
+		v.mutex.Lock()
+		for articleId, views := range counts {
+			v.counts[articleId] += views
+		}
+		v.mutex.Unlock()
+	}
+	return err
+}